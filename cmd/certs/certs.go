@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/urans/kubemaze/pkg/tour"
+)
+
+// Exit codes follow monitoring plugins: 0 ok, 1 warning, 2 critical, 3 unknown
+const exitUnknown = 3
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	namespace := flag.String("namespace", "", "namespace to scan, empty means all namespaces")
+	output := flag.String("o", "table", "output format: table or json")
+	warning := flag.Int("warning", tour.DefaultCertThresholds.Warning, "days left from which a certificate is a warning")
+	critical := flag.Int("critical", tour.DefaultCertThresholds.Critical, "days left from which a certificate is critical")
+	skipSecrets := flag.Bool("skip-secrets", false, "only scan the kubeconfig certificates")
+	flag.Parse()
+
+	thresholds := tour.CertThresholds{Warning: *warning, Critical: *critical}
+	certs, err := tour.ScanKubeconfigCerts(*kubeconfig, thresholds)
+	if err != nil {
+		slog.Error("scan kubeconfig certificates failed", "error", err)
+		os.Exit(exitUnknown)
+	}
+
+	if !*skipSecrets {
+		clientset, err := tour.NewKubeClient(*kubeconfig)
+		if err != nil {
+			slog.Error("create kube client failed", "error", err)
+			os.Exit(exitUnknown)
+		}
+		secretCerts, err := tour.ScanSecretCerts(clientset, *namespace, thresholds)
+		if err != nil {
+			slog.Error("scan secret certificates failed", "error", err)
+			os.Exit(exitUnknown)
+		}
+		certs = append(certs, secretCerts...)
+	}
+
+	if err := tour.WriteCertReport(os.Stdout, *output, certs); err != nil {
+		slog.Error("write certificate report failed", "error", err)
+		os.Exit(exitUnknown)
+	}
+	os.Exit(tour.CertExitCode(certs))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/urans/kubemaze/pkg/tour"
+)
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	namespace := flag.String("namespace", "", "namespace to inventory, empty means all namespaces")
+	output := flag.String("o", "table", "output format: table, json or csv")
+	flag.Parse()
+
+	clientset, err := tour.NewKubeClient(*kubeconfig)
+	if err != nil {
+		slog.Error("create kube client failed", "error", err)
+		os.Exit(1)
+	}
+
+	images, err := tour.BuildImageInventory(clientset, *namespace)
+	if err != nil {
+		slog.Error("build image inventory failed", "error", err)
+		os.Exit(1)
+	}
+	if err := tour.WriteImageInventory(os.Stdout, *output, images); err != nil {
+		slog.Error("write image inventory failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/urans/kubemaze/pkg/crashloop"
+	"github.com/urans/kubemaze/pkg/informer"
+	"github.com/urans/kubemaze/pkg/kubewatch"
+	"github.com/urans/kubemaze/pkg/tour"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// runCrashLoop detects restart storms of workloads, recording events on them
+// and alerting through the kubewatch sinks
+func runCrashLoop(args []string) {
+	flags := flag.NewFlagSet("crashloop", flag.ExitOnError)
+	kubeconfig := flags.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	namespace := flags.String("namespace", "", "namespace to watch, empty means all namespaces")
+	window := flags.Duration("window", 10*time.Minute, "how far back container restarts are counted")
+	threshold := flags.Int("threshold", 5, "container restarts of a workload within the window making a storm")
+	sinks := flags.String("sinks", "", "path to a kubewatch sinks config file, alerts are printed to stdout when empty")
+	events := flags.Bool("events", true, "record a warning event on the workloads in a storm")
+	flags.Parse(args)
+
+	cfg := &kubewatch.Config{Sinks: []kubewatch.SinkConfig{{Type: "stdout", Format: "text"}}}
+	if *sinks != "" {
+		var err error
+		if cfg, err = kubewatch.LoadConfig(*sinks); err != nil {
+			slog.Error("load sinks config failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	dispatcher, err := kubewatch.NewDispatcher(cfg)
+	if err != nil {
+		slog.Error("create sinks failed", "error", err)
+		os.Exit(1)
+	}
+
+	clientset, err := tour.NewKubeClient(*kubeconfig)
+	if err != nil {
+		slog.Error("create kube client failed", "error", err)
+		os.Exit(1)
+	}
+	informers, err := informer.New(clientset, []string{"pods", "replicasets.apps"}, []string{*namespace}, 0)
+	if err != nil {
+		slog.Error("create informers failed", "error", err)
+		os.Exit(1)
+	}
+
+	var recorder record.EventRecorder
+	if *events {
+		broadcaster := record.NewBroadcaster()
+		defer broadcaster.Shutdown()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "crashloop-detector"})
+	}
+	alerts := make(chan kubewatch.Event)
+	report := crashloop.NewReporter(recorder, alerts)
+	detector := crashloop.NewDetector(informers.Factory(*namespace).Apps().V1().ReplicaSets().Lister(), crashloop.Options{
+		Window:    *window,
+		Threshold: *threshold,
+	}, func(s crashloop.Storm) {
+		slog.Warn("restart storm", "kind", s.Workload.Kind, "namespace", s.Workload.Namespace, "name", s.Workload.Name, "restarts", s.Restarts)
+		report(s)
+	})
+	for _, s := range informers.Sources() {
+		if s.Resource.Kind != "Pod" {
+			continue
+		}
+		if _, err := s.Informer.AddEventHandler(detector.EventHandler()); err != nil {
+			slog.Error("add event handler failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Run(ctx, alerts)
+	}()
+	if err := informers.Start(ctx); err != nil {
+		slog.Error("start informers failed", "error", err)
+	} else {
+		<-ctx.Done()
+	}
+	// The handlers are stopped once shut down, nothing sends alerts anymore
+	informers.Shutdown()
+	close(alerts)
+	<-done
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urans/kubemaze/pkg/exporter"
+	"github.com/urans/kubemaze/pkg/informer"
+	"github.com/urans/kubemaze/pkg/tour"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+)
+
+// runExporter serves gauges of the cluster objects cached by the shared
+// informers on /metrics
+func runExporter(args []string) {
+	flags := flag.NewFlagSet("exporter", flag.ExitOnError)
+	kubeconfig := flags.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	addr := flags.String("addr", ":9100", "address serving the metrics")
+	config := flags.String("config", "", "path to a metrics config file, the built-in metrics are exported when empty")
+	resync := flags.Duration("resync", 10*time.Minute, "resync period of the informers, 0 disables resyncs")
+	flags.Parse(args)
+
+	cfg := exporter.DefaultConfig()
+	if *config != "" {
+		var err error
+		if cfg, err = exporter.LoadConfig(*config); err != nil {
+			slog.Error("load metrics config failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	clientset, err := tour.NewKubeClient(*kubeconfig)
+	if err != nil {
+		slog.Error("create kube client failed", "error", err)
+		os.Exit(1)
+	}
+	dyn, err := tour.NewKubeClientDynamic(*kubeconfig)
+	if err != nil {
+		slog.Error("create dynamic client failed", "error", err)
+		os.Exit(1)
+	}
+	informers, err := informer.New(clientset, nil, nil, *resync)
+	if err != nil {
+		slog.Error("create informers failed", "error", err)
+		os.Exit(1)
+	}
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, *resync)
+	exp, err := exporter.New(cfg, informers.Factory(""), dynamicFactory)
+	if err != nil {
+		slog.Error("create exporter failed", "error", err)
+		os.Exit(1)
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(exp); err != nil {
+		slog.Error("register exporter failed", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	defer informers.Shutdown()
+	defer dynamicFactory.Shutdown()
+	dynamicFactory.Start(ctx.Done())
+	if err := informers.Start(ctx); err != nil {
+		slog.Error("start informers failed", "error", err)
+		return
+	}
+	// A missing custom resource never syncs, its metric stays empty
+	syncCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	for gvr, synced := range dynamicFactory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			slog.Warn("cache not synced", "resource", gvr.String())
+		}
+	}
+	cancel()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	serve(ctx, "metrics", *addr, mux)
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urans/kubemaze/pkg/informer"
+	"github.com/urans/kubemaze/pkg/lifecycle"
 	"github.com/urans/kubemaze/pkg/tour"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -35,32 +43,125 @@ func initLogger() {
 
 func main() {
 	initLogger()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "crashloop":
+			runCrashLoop(os.Args[2:])
+			return
+		case "exporter":
+			runExporter(os.Args[2:])
+			return
+		}
+	}
+
+	kubeconfig := flag.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	resources := flag.String("resources", "pods", "comma separated resources to inform about, like pods,deployments.apps,nodes")
+	namespaces := flag.String("namespaces", "", "comma separated namespaces, empty means all namespaces")
+	resync := flag.Duration("resync", time.Minute, "resync period of the informers, 0 disables resyncs")
+	dashboard := flag.Duration("dashboard", 0, "how often to print the object counts, 0 disables the dashboard")
+	lifecycleAddr := flag.String("lifecycle-addr", "", "address serving the pod timelines, startup latencies and metrics, empty disables it")
+	retention := flag.Duration("retention", time.Hour, "how long to keep the timelines of terminated pods")
+	flag.Parse()
+
+	resourceList := splitList(*resources)
+	if *lifecycleAddr != "" && !slices.ContainsFunc(resourceList, isPods) {
+		resourceList = append(resourceList, "pods")
+	}
 
-	local := path.Join(os.Getenv("HOME"), ".kube/config")
-	clientset, err := tour.NewKubeClient(local)
+	clientset, err := tour.NewKubeClient(*kubeconfig)
 	if err != nil {
 		slog.Error("create kube client failed", "error", err)
+		os.Exit(1)
 	}
 
-	factory := informers.NewSharedInformerFactory(clientset, time.Minute)
-	informer := factory.Core().V1().Pods().Informer()
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj any) {
-			val := obj.(v1.Object)
-			slog.Info("New Pod Created", "namespace", val.GetNamespace(), "pod", val.GetName())
-		},
-		UpdateFunc: func(oldObj, newObj any) {
-			old := oldObj.(v1.Object)
-			another := newObj.(v1.Object)
-			slog.Info("Pod Updated", "namespace", old.GetNamespace(), "old", old.GetName(), "new", another.GetName())
-		},
-		DeleteFunc: func(obj any) {
-			val := obj.(v1.Object)
-			slog.Info("Pod Deleted", "namespace", val.GetNamespace(), "pod", val.GetName())
-		},
+	informers, err := informer.New(clientset, resourceList, splitList(*namespaces), *resync)
+	if err != nil {
+		slog.Error("create informers failed", "error", err)
+		os.Exit(1)
+	}
+	err = informers.AddEventHandler(func(s informer.Source) cache.ResourceEventHandler {
+		return informer.NewHandler(s, logChange)
 	})
+	if err != nil {
+		slog.Error("add event handler failed", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *lifecycleAddr != "" {
+		registry := prometheus.NewRegistry()
+		tracker, err := lifecycle.NewTracker(registry, *retention)
+		if err != nil {
+			slog.Error("create lifecycle tracker failed", "error", err)
+			os.Exit(1)
+		}
+		for _, s := range informers.Sources() {
+			if s.Resource.Kind != "Pod" {
+				continue
+			}
+			if _, err := s.Informer.AddEventHandler(tracker.EventHandler()); err != nil {
+				slog.Error("add lifecycle handler failed", "error", err)
+				os.Exit(1)
+			}
+		}
+		go serve(ctx, "pod lifecycle", *lifecycleAddr, tracker.Handler(registry))
+	}
+	defer informers.Shutdown()
+	if err := informers.Start(ctx); err != nil {
+		slog.Error("start informers failed", "error", err)
+		return
+	}
+	if *dashboard <= 0 {
+		<-ctx.Done()
+		return
+	}
+	ticker := time.NewTicker(*dashboard)
+	defer ticker.Stop()
+	for {
+		if err := informers.WriteDashboard(os.Stdout); err != nil {
+			slog.Error("write dashboard failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve runs an HTTP server until ctx is done
+func serve(ctx context.Context, name, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	slog.Info("serving "+name, "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("serve "+name+" failed", "error", err)
+	}
+}
+
+func isPods(arg string) bool {
+	r, err := informer.ResolveResource(arg)
+	return err == nil && r.Kind == "Pod"
+}
+
+func logChange(c informer.Change) {
+	args := []any{"type", c.Type, "kind", c.Kind, "namespace", c.Namespace, "name", c.Name}
+	if len(c.Summary) > 0 {
+		args = append(args, "changes", strings.Join(c.Summary, ", "))
+	}
+	slog.Info("received event", args...)
+}
 
-	quit := make(chan struct{})
-	defer close(quit)
-	informer.Run(quit)
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -2,73 +2,170 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path"
-	"sync"
+	"strings"
 
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/cache"
-	toolwatch "k8s.io/client-go/tools/watch"
 
+	"github.com/urans/kubemaze/pkg/jsondiff"
+	"github.com/urans/kubemaze/pkg/kubewatch"
+	"github.com/urans/kubemaze/pkg/replay"
 	"github.com/urans/kubemaze/pkg/tour"
 )
 
-var watchTimeoutSeconds = int64(20)
-
 func main() {
-	clientset, err := tour.NewKubeClient(path.Join(os.Getenv("HOME"), ".kube/config"))
+	kubeconfig := flag.String("kubeconfig", path.Join(os.Getenv("HOME"), ".kube/config"), "path to the kubeconfig file")
+	namespace := flag.String("namespace", "", "namespace to watch, empty means all namespaces")
+	rediscover := flag.Duration("rediscover", 0, "how often missing resources are looked up again, 30s by default")
+	skipInitial := flag.Bool("skip-initial", false, "skip the Added events of objects existing at start")
+	output := flag.String("o", "text", "output format: text, json or yaml")
+	rule := flag.String("filter", "", `CEL rule selecting the printed or dispatched events, like type == "DELETED" && object.kind == "Namespace"`)
+	sinks := flag.String("sinks", "", "path to a sinks config file, events are printed to stdout when empty")
+	checkpoint := flag.String("checkpoint", "", "file or configmap:namespace/name to resume from after a restart, empty to start over")
+	record := flag.String("record", "", "path to a JSONL file recording the watched events with full objects for replay")
+	ignore := flag.String("ignore", strings.Join(jsondiff.DefaultIgnored, ","), "comma separated paths left out of Modified diffs, empty to diff everything")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [resource ...]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Resources are like pods, deployments.apps or memcacheds.cache.urans.com, namespaces by default.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	resources := flag.Args()
+	if len(resources) == 0 {
+		resources = []string{"namespaces"}
+	}
+
+	var ignored []string
+	if *ignore != "" {
+		ignored = strings.Split(*ignore, ",")
+	}
+	printer, err := kubewatch.NewPrinter(os.Stdout, *output, ignored)
 	if err != nil {
-		slog.Error("create kube client failed", "error", err)
+		slog.Error("create printer failed", "error", err)
 		os.Exit(1)
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go watchNamespaces(clientset)
-	wg.Wait()
-}
-
-func watchNamespaces(clientset kubernetes.Interface) error {
-	// * Create Watcher Without Retry
-	// watcher, err := clientset.CoreV1().Namespaces().Watch(
-	// context.Background(), metav1.ListOptions{TimeoutSeconds: &watchTimeoutSeconds})
-	// if err != nil {
-	// 	return err
-	// }
+	filter, err := kubewatch.NewFilter(kubewatch.FilterConfig{Rule: *rule})
+	if err != nil {
+		slog.Error("compile filter failed", "error", err)
+		os.Exit(1)
+	}
 
-	watchFn := func(opts metav1.ListOptions) (watch.Interface, error) {
-		return clientset.CoreV1().Namespaces().Watch(
-			context.Background(), metav1.ListOptions{
-				TimeoutSeconds: &watchTimeoutSeconds,
-			},
-		)
+	var dispatcher *kubewatch.Dispatcher
+	if *sinks != "" {
+		cfg, err := kubewatch.LoadConfig(*sinks)
+		if err != nil {
+			slog.Error("load sinks config failed", "error", err)
+			os.Exit(1)
+		}
+		if dispatcher, err = kubewatch.NewDispatcher(cfg); err != nil {
+			slog.Error("create sinks failed", "error", err)
+			os.Exit(1)
+		}
 	}
 
-	// * Create Watcher With Retry
-	watcher, err := toolwatch.NewRetryWatcher("1", &cache.ListWatch{WatchFunc: watchFn})
+	config, err := tour.NewRestConfig(*kubeconfig)
 	if err != nil {
-		slog.Error("create retry watcher failed", "error", err)
-		return err
+		slog.Error("create rest config failed", "error", err)
+		os.Exit(1)
+	}
+	disc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		slog.Error("create discovery client failed", "error", err)
+		os.Exit(1)
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		slog.Error("create dynamic client failed", "error", err)
+		os.Exit(1)
+	}
+
+	var checkpoints kubewatch.CheckpointStore
+	if *checkpoint != "" {
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			slog.Error("create kube client failed", "error", err)
+			os.Exit(1)
+		}
+		if checkpoints, err = kubewatch.NewCheckpointStore(*checkpoint, clientset); err != nil {
+			slog.Error("create checkpoint store failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	var recorder *replay.Recorder
+	if *record != "" {
+		file, err := os.Create(*record)
+		if err != nil {
+			slog.Error("create recording failed", "error", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		recorder = replay.NewRecorder(file)
 	}
 
-	for event := range watcher.ResultChan() {
-		ns := event.Object.(*corev1.Namespace)
-		switch event.Type {
-		case watch.Modified:
-		case watch.Bookmark:
-		case watch.Deleted:
-		case watch.Error:
-		case watch.Added:
-			processNamespaces(ns.GetName(), event.Type)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	watcher := kubewatch.NewWatcher(disc, dyn, resources, kubewatch.Options{
+		Namespace:   *namespace,
+		Rediscover:  *rediscover,
+		SkipInitial: *skipInitial,
+		Checkpoints: checkpoints,
+	})
+	events := watcher.Run(ctx)
+	if recorder != nil {
+		events = recordEvents(recorder, events)
+	}
+	// The filter applies before the per sink filters
+	events = filterEvents(filter, events)
+	if dispatcher != nil {
+		dispatcher.Run(ctx, events)
+		return
+	}
+	for event := range events {
+		if err := printer.Print(event); err != nil {
+			slog.Error("print event failed", "error", err)
+			os.Exit(1)
 		}
+		event.Ack()
 	}
-	return nil
 }
 
-func processNamespaces(namespace string, event watch.EventType) {
-	slog.Info("received event", "type", event, "namespaces", namespace)
+// filterEvents passes on the events matching filter, acknowledging the others
+func filterEvents(filter *kubewatch.Filter, events <-chan kubewatch.Event) <-chan kubewatch.Event {
+	filtered := make(chan kubewatch.Event)
+	go func() {
+		defer close(filtered)
+		for event := range events {
+			if !filter.Match(event) {
+				event.Ack()
+				continue
+			}
+			filtered <- event
+		}
+	}()
+	return filtered
+}
+
+// recordEvents records each event before passing it on
+func recordEvents(recorder *replay.Recorder, events <-chan kubewatch.Event) <-chan kubewatch.Event {
+	recorded := make(chan kubewatch.Event)
+	go func() {
+		defer close(recorded)
+		for event := range events {
+			if err := recorder.Record(event.Type, event.Object); err != nil {
+				slog.Error("record event failed", "error", err)
+			}
+			recorded <- event
+		}
+	}()
+	return recorded
 }
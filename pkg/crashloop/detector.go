@@ -0,0 +1,245 @@
+package crashloop
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urans/kubemaze/pkg/tour"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Reason is the reason of the events and the type of the alerts of a storm
+const Reason = "RestartStorm"
+
+// Options tunes when restarts make a storm
+type Options struct {
+	// Window is how far back restarts are counted, 10 minutes when zero.
+	Window time.Duration
+	// Threshold is the restarts within the window making a storm, 5 when zero.
+	Threshold int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Window <= 0 {
+		o.Window = 10 * time.Minute
+	}
+	if o.Threshold <= 0 {
+		o.Threshold = 5
+	}
+	return o
+}
+
+// Storm is a workload whose containers restarted too often within the window
+type Storm struct {
+	Time     time.Time
+	Workload corev1.ObjectReference
+	Window   time.Duration
+	Restarts int
+	// Pods are the restarted pods, sorted.
+	Pods []string
+	// Reasons counts the restarts by the reason the containers last terminated,
+	// like Error or OOMKilled.
+	Reasons map[string]int
+	// CrashLooping are the containers backing off, as pod/container.
+	CrashLooping []string
+}
+
+// Message describes the storm in one line
+func (s Storm) Message() string {
+	reasons := make([]string, 0, len(s.Reasons))
+	for _, reason := range slices.Sorted(maps.Keys(s.Reasons)) {
+		reasons = append(reasons, fmt.Sprintf("%s %d", reason, s.Reasons[reason]))
+	}
+	msg := fmt.Sprintf("%d container restarts of %d pods in %s (%s)", s.Restarts, len(s.Pods), s.Window, strings.Join(reasons, ", "))
+	if len(s.CrashLooping) > 0 {
+		msg += ", crash looping: " + strings.Join(s.CrashLooping, ", ")
+	}
+	return msg
+}
+
+type restart struct {
+	time   time.Time
+	pod    string
+	reason string
+}
+
+type workload struct {
+	ref      corev1.ObjectReference
+	restarts []restart
+	flagged  time.Time
+}
+
+type podState struct {
+	// counts are the last seen restart counts by container.
+	counts map[string]int32
+	// looping are the containers in CrashLoopBackOff, as pod/container.
+	looping []string
+	// owner is the workload, resolved on the first restart.
+	owner *corev1.ObjectReference
+}
+
+// Detector counts the container restarts per workload from a pod informer and
+// reports a storm once they reach the threshold within the window. A workload
+// is reported again at most once per window.
+type Detector struct {
+	mu          sync.Mutex
+	opts        Options
+	replicaSets appslisters.ReplicaSetLister
+	report      func(Storm)
+	pods        map[types.UID]*podState
+	workloads   map[corev1.ObjectReference]*workload
+	now         func() time.Time
+}
+
+// NewDetector creates a detector resolving the Deployments of pods through the
+// ReplicaSets of the lister, which may be nil to go by the pod-template-hash
+func NewDetector(replicaSets appslisters.ReplicaSetLister, opts Options, report func(Storm)) *Detector {
+	return &Detector{
+		opts:        opts.withDefaults(),
+		replicaSets: replicaSets,
+		report:      report,
+		pods:        map[types.UID]*podState{},
+		workloads:   map[corev1.ObjectReference]*workload{},
+		now:         time.Now,
+	}
+}
+
+// EventHandler feeds the detector from a pod informer. The restarts before a
+// pod was first seen are not counted.
+func (d *Detector) EventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { d.observe(obj) },
+		UpdateFunc: func(_, obj any) { d.observe(obj) },
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				d.forget(pod)
+			}
+		},
+	}
+}
+
+func (d *Detector) observe(obj any) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	state, seen := d.pods[pod.UID]
+	if !seen {
+		state = &podState{counts: map[string]int32{}}
+		d.pods[pod.UID] = state
+	}
+	now := d.now()
+	var restarts []restart
+	state.looping = nil
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+			state.looping = append(state.looping, pod.Name+"/"+status.Name)
+		}
+		last, ok := state.counts[status.Name]
+		state.counts[status.Name] = status.RestartCount
+		if !seen || !ok {
+			continue
+		}
+		for range status.RestartCount - last {
+			restarts = append(restarts, restart{time: now, pod: pod.Name, reason: terminationReason(status)})
+		}
+	}
+	owner := state.owner
+	d.mu.Unlock()
+	if len(restarts) == 0 {
+		return
+	}
+
+	// Resolve the owner outside the lock, it is the same for the pod's lifetime
+	if owner == nil {
+		ref := d.Owner(pod)
+		owner = &ref
+	}
+	d.mu.Lock()
+	if state, ok := d.pods[pod.UID]; ok {
+		state.owner = owner
+	}
+	// Keyed by the whole reference, a Deployment told by its hash has no UID
+	w := d.workloads[*owner]
+	if w == nil {
+		w = &workload{ref: *owner}
+		d.workloads[*owner] = w
+	}
+	w.restarts = append(w.restarts, restarts...)
+	storm, ok := d.check(w, now)
+	d.mu.Unlock()
+	if ok {
+		d.report(storm)
+	}
+}
+
+// check drops the restarts out of the window and tells whether they make a new storm
+func (d *Detector) check(w *workload, now time.Time) (Storm, bool) {
+	cutoff := now.Add(-d.opts.Window)
+	w.restarts = slices.DeleteFunc(w.restarts, func(r restart) bool { return r.time.Before(cutoff) })
+	if len(w.restarts) < d.opts.Threshold || (!w.flagged.IsZero() && w.flagged.After(cutoff)) {
+		return Storm{}, false
+	}
+	w.flagged = now
+	storm := Storm{
+		Time:     now,
+		Workload: w.ref,
+		Window:   d.opts.Window,
+		Restarts: len(w.restarts),
+		Reasons:  map[string]int{},
+	}
+	for _, r := range w.restarts {
+		storm.Reasons[r.reason]++
+		if !slices.Contains(storm.Pods, r.pod) {
+			storm.Pods = append(storm.Pods, r.pod)
+		}
+	}
+	slices.Sort(storm.Pods)
+	for _, state := range d.pods {
+		if state.owner != nil && *state.owner == w.ref {
+			storm.CrashLooping = append(storm.CrashLooping, state.looping...)
+		}
+	}
+	slices.Sort(storm.CrashLooping)
+	return storm, true
+}
+
+// forget drops a deleted pod, its restarts still count for the workload, and
+// the workloads quiet for a window
+func (d *Detector) forget(pod *corev1.Pod) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pods, pod.UID)
+	cutoff := d.now().Add(-d.opts.Window)
+	for ref, w := range d.workloads {
+		quiet := !slices.ContainsFunc(w.restarts, func(r restart) bool { return !r.time.Before(cutoff) })
+		if quiet && w.flagged.Before(cutoff) {
+			delete(d.workloads, ref)
+		}
+	}
+}
+
+// Owner resolves the workload of a pod following its controllers: a pod of a
+// Deployment resolves to the Deployment, a bare pod to itself
+func (d *Detector) Owner(pod *corev1.Pod) corev1.ObjectReference {
+	return tour.PodWorkload(pod, d.replicaSets)
+}
+
+// terminationReason is why a restarted container last terminated
+func terminationReason(status corev1.ContainerStatus) string {
+	if t := status.LastTerminationState.Terminated; t != nil && t.Reason != "" {
+		return t.Reason
+	}
+	return "Unknown"
+}
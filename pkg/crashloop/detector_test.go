@@ -0,0 +1,137 @@
+package crashloop
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// crashPod is a pod of the web Deployment whose container restarted, last
+// terminated for reason and possibly backing off
+func crashPod(name string, restarts int32, reason string, looping bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "shop",
+			Name:      name,
+			UID:       types.UID("uid-" + name),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7", UID: "uid-rs", Controller: new(true),
+			}},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "web", RestartCount: restarts}}},
+	}
+	if reason != "" {
+		pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: reason}
+	}
+	if looping {
+		pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+	}
+	return pod
+}
+
+func replicaSetLister(t *testing.T) appslisters.ReplicaSetLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	err := indexer.Add(&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "shop",
+		Name:      "web-5d8f7",
+		UID:       "uid-rs",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "Deployment", Name: "web", UID: "uid-deploy", Controller: new(true),
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appslisters.NewReplicaSetLister(indexer)
+}
+
+func TestOwner(t *testing.T) {
+	bare := crashPod("debug", 0, "", false)
+	bare.OwnerReferences = nil
+	orphan := crashPod("api", 0, "", false)
+	orphan.OwnerReferences[0].Name = "api-7c9d"
+	hashed := crashPod("web-1", 0, "", false)
+	hashed.Labels = map[string]string{"pod-template-hash": "5d8f7"}
+
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		replicaSets appslisters.ReplicaSetLister
+		want        string
+	}{
+		{name: "deployment", pod: crashPod("web-1", 0, "", false), replicaSets: replicaSetLister(t), want: "Deployment/web"},
+		{name: "replicaset not cached", pod: orphan, replicaSets: replicaSetLister(t), want: "ReplicaSet/api-7c9d"},
+		{name: "no lister", pod: crashPod("web-1", 0, "", false), want: "ReplicaSet/web-5d8f7"},
+		{name: "no lister with hash", pod: hashed, want: "Deployment/web"},
+		{name: "bare pod", pod: bare, replicaSets: replicaSetLister(t), want: "Pod/debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := NewDetector(tt.replicaSets, Options{}, nil).Owner(tt.pod)
+			if got := ref.Kind + "/" + ref.Name; got != tt.want || ref.Namespace != "shop" {
+				t.Errorf("Owner() = %+v, want %s", ref, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetector(t *testing.T) {
+	var storms []Storm
+	d := NewDetector(replicaSetLister(t), Options{Window: 10 * time.Minute, Threshold: 4}, func(s Storm) {
+		storms = append(storms, s)
+	})
+	now := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	handler := d.EventHandler()
+
+	// The restarts before the pods were seen do not count
+	handler.OnAdd(crashPod("web-1", 7, "Error", false), true)
+	handler.OnAdd(crashPod("web-2", 0, "", false), true)
+	handler.OnUpdate(nil, crashPod("web-1", 8, "Error", false))
+	handler.OnUpdate(nil, crashPod("web-2", 1, "OOMKilled", false))
+	if len(storms) != 0 {
+		t.Fatalf("storms = %+v before the threshold", storms)
+	}
+
+	// The restarts out of the window are dropped
+	now = now.Add(11 * time.Minute)
+	handler.OnUpdate(nil, crashPod("web-1", 10, "Error", false))
+	if len(storms) != 0 {
+		t.Fatalf("storms = %+v with old restarts", storms)
+	}
+	now = now.Add(time.Minute)
+	handler.OnUpdate(nil, crashPod("web-2", 3, "OOMKilled", true))
+	if len(storms) != 1 {
+		t.Fatalf("storms = %d, want 1", len(storms))
+	}
+	storm := storms[0]
+	if storm.Workload.Kind != "Deployment" || storm.Workload.Name != "web" || storm.Workload.UID != "uid-deploy" ||
+		storm.Restarts != 4 || !slices.Equal(storm.Pods, []string{"web-1", "web-2"}) ||
+		storm.Reasons["Error"] != 2 || storm.Reasons["OOMKilled"] != 2 ||
+		!slices.Equal(storm.CrashLooping, []string{"web-2/web"}) {
+		t.Errorf("storm = %+v", storm)
+	}
+	want := "4 container restarts of 2 pods in 10m0s (Error 2, OOMKilled 2), crash looping: web-2/web"
+	if got := storm.Message(); got != want {
+		t.Errorf("Message() = %s, want %s", got, want)
+	}
+
+	// A storm is reported once per window, the restarts of deleted pods still count
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "shop/web-2", Obj: crashPod("web-2", 3, "OOMKilled", true)})
+	handler.OnUpdate(nil, crashPod("web-1", 11, "Error", false))
+	if len(storms) != 1 {
+		t.Fatalf("storms = %d, want no repeat within the window", len(storms))
+	}
+	now = now.Add(10*time.Minute + time.Second)
+	handler.OnUpdate(nil, crashPod("web-1", 15, "Error", false))
+	if len(storms) != 2 || storms[1].Restarts != 4 || !slices.Equal(storms[1].Pods, []string{"web-1"}) || storms[1].CrashLooping != nil {
+		t.Errorf("storms = %+v, want a second storm of web-1", storms)
+	}
+}
@@ -0,0 +1,36 @@
+package crashloop
+
+import (
+	"github.com/urans/kubemaze/pkg/kubewatch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
+)
+
+// Alert turns the storm into an event for the kubewatch sinks, its type is Reason
+func (s Storm) Alert() kubewatch.Event {
+	gvk := schema.FromAPIVersionAndKind(s.Workload.APIVersion, s.Workload.Kind)
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(s.Workload.Namespace)
+	obj.SetName(s.Workload.Name)
+	obj.SetUID(s.Workload.UID)
+	return kubewatch.Event{Type: watch.EventType(Reason), Resource: gvr, Object: obj, Message: s.Message()}
+}
+
+// NewReporter records a warning event on the workload of a storm and sends its
+// alert, either of recorder and alerts may be nil
+func NewReporter(recorder record.EventRecorder, alerts chan<- kubewatch.Event) func(Storm) {
+	return func(s Storm) {
+		if recorder != nil {
+			recorder.Event(&s.Workload, corev1.EventTypeWarning, Reason, s.Message())
+		}
+		if alerts != nil {
+			alerts <- s.Alert()
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package crashloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/urans/kubemaze/pkg/kubewatch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReporter(t *testing.T) {
+	storm := Storm{
+		Workload: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "shop", Name: "web", UID: "uid-deploy"},
+		Window:   10 * time.Minute,
+		Restarts: 5,
+		Pods:     []string{"web-1"},
+		Reasons:  map[string]int{"Error": 5},
+	}
+	recorder := record.NewFakeRecorder(1)
+	alerts := make(chan kubewatch.Event, 1)
+	NewReporter(recorder, alerts)(storm)
+
+	want := "Warning RestartStorm 5 container restarts of 1 pods in 10m0s (Error 5)"
+	if got := <-recorder.Events; got != want {
+		t.Errorf("event = %s, want %s", got, want)
+	}
+	alert := <-alerts
+	if alert.Type != Reason || alert.Message != storm.Message() ||
+		alert.Resource != (schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}) ||
+		alert.Object.GetKind() != "Deployment" || alert.Object.GetNamespace() != "shop" || alert.Object.GetName() != "web" {
+		t.Errorf("alert = %+v", alert)
+	}
+
+	// Either output is optional
+	NewReporter(nil, nil)(storm)
+}
@@ -0,0 +1,288 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultCapacity is the number of distinct events kept by a collector
+const DefaultCapacity = 4096
+
+// Event is a deduplicated series of events about the same object
+type Event struct {
+	Type      string                 `json:"type"`
+	Reason    string                 `json:"reason"`
+	Note      string                 `json:"note"`
+	Regarding corev1.ObjectReference `json:"regarding"`
+	Reporter  string                 `json:"reporter,omitempty"`
+	FirstSeen time.Time              `json:"firstSeen"`
+	LastSeen  time.Time              `json:"lastSeen"`
+	Count     int32                  `json:"count"`
+
+	// counts holds the occurrences per Event object merged into the series
+	counts map[string]int32
+}
+
+// key identifies a series, the same occurrence seen through both Event APIs and
+// recreated Event objects for a repeating problem fold into one.
+func (e *Event) key() string {
+	r := e.Regarding
+	return fmt.Sprintf("%s/%s/%s/%s|%s|%s|%s", r.Kind, r.Namespace, r.Name, r.UID, e.Type, e.Reason, e.Note)
+}
+
+// Collector watches events.k8s.io/v1 and core Events into a bounded ring buffer
+type Collector struct {
+	clientset kubernetes.Interface
+
+	mu    sync.RWMutex
+	ring  []*Event
+	next  int
+	index map[string]*Event
+}
+
+// NewCollector creates a collector keeping the latest capacity event series
+func NewCollector(clientset kubernetes.Interface, capacity int) *Collector {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Collector{
+		clientset: clientset,
+		ring:      make([]*Event, capacity),
+		index:     make(map[string]*Event),
+	}
+}
+
+// Run watches the Events of all namespaces through both APIs until ctx is done
+func (c *Collector) Run(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactory(c.clientset, 0)
+	eventsInformer := factory.Events().V1().Events().Informer()
+	coreInformer := factory.Core().V1().Events().Informer()
+
+	_, err := eventsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { c.addEventsV1(obj) },
+		UpdateFunc: func(_, obj any) { c.addEventsV1(obj) },
+	})
+	if err != nil {
+		return err
+	}
+	_, err = coreInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { c.addCoreV1(obj) },
+		UpdateFunc: func(_, obj any) { c.addCoreV1(obj) },
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			slog.Error("events informer cache sync failed", "type", typ.String())
+			return fmt.Errorf("sync %s informer failed", typ)
+		}
+	}
+	slog.Info("events collector started", "capacity", len(c.ring))
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Collector) addEventsV1(obj any) {
+	e, ok := obj.(*eventsv1.Event)
+	if !ok {
+		return
+	}
+	count := int32(1)
+	last := e.EventTime.Time
+	if e.Series != nil {
+		count = e.Series.Count
+		last = e.Series.LastObservedTime.Time
+	}
+	if e.DeprecatedCount > count {
+		count = e.DeprecatedCount
+	}
+	if last.IsZero() {
+		last = e.DeprecatedLastTimestamp.Time
+	}
+	first := e.DeprecatedFirstTimestamp.Time
+	if first.IsZero() {
+		first = e.EventTime.Time
+	}
+	c.Add(e.Namespace+"/"+e.Name, Event{
+		Type:      e.Type,
+		Reason:    e.Reason,
+		Note:      e.Note,
+		Regarding: e.Regarding,
+		Reporter:  e.ReportingController,
+		FirstSeen: first,
+		LastSeen:  last,
+		Count:     count,
+	})
+}
+
+func (c *Collector) addCoreV1(obj any) {
+	e, ok := obj.(*corev1.Event)
+	if !ok {
+		return
+	}
+	count := max(e.Count, 1)
+	last := e.LastTimestamp.Time
+	if e.Series != nil {
+		count = max(count, e.Series.Count)
+		last = e.Series.LastObservedTime.Time
+	}
+	if last.IsZero() {
+		last = e.EventTime.Time
+	}
+	first := e.FirstTimestamp.Time
+	if first.IsZero() {
+		first = e.EventTime.Time
+	}
+	reporter := e.ReportingController
+	if reporter == "" {
+		reporter = e.Source.Component
+	}
+	c.Add(e.Namespace+"/"+e.Name, Event{
+		Type:      e.Type,
+		Reason:    e.Reason,
+		Note:      e.Message,
+		Regarding: e.InvolvedObject,
+		Reporter:  reporter,
+		FirstSeen: first,
+		LastSeen:  last,
+		Count:     count,
+	})
+}
+
+// Add merges an occurrence of the Event object name into its series, the
+// oldest series is evicted once the buffer is full.
+func (c *Collector) Add(name string, e Event) {
+	if e.FirstSeen.IsZero() {
+		e.FirstSeen = e.LastSeen
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := e.key()
+	if series, ok := c.index[key]; ok {
+		// Both APIs report the same Event object, only its highest count is kept
+		series.counts[name] = max(series.counts[name], e.Count)
+		series.Count = 0
+		for _, n := range series.counts {
+			series.Count += n
+		}
+		if e.LastSeen.After(series.LastSeen) {
+			series.LastSeen = e.LastSeen
+		}
+		if !e.FirstSeen.IsZero() && e.FirstSeen.Before(series.FirstSeen) {
+			series.FirstSeen = e.FirstSeen
+		}
+		return
+	}
+
+	if old := c.ring[c.next]; old != nil {
+		delete(c.index, old.key())
+	}
+	e.counts = map[string]int32{name: e.Count}
+	c.ring[c.next] = &e
+	c.index[key] = &e
+	c.next = (c.next + 1) % len(c.ring)
+}
+
+// Len returns the number of event series in the buffer
+func (c *Collector) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.index)
+}
+
+// Query returns the series regarding any of refs last seen after since, oldest
+// first. Refs match on kind, namespace and name, an empty kind or name matches any.
+func (c *Collector) Query(since time.Time, refs ...corev1.ObjectReference) []Event {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	found := []Event{}
+	for _, e := range c.index {
+		if e.LastSeen.Before(since) {
+			continue
+		}
+		for _, ref := range refs {
+			if matchRef(ref, e.Regarding) {
+				found = append(found, *e)
+				break
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].LastSeen.Equal(found[j].LastSeen) {
+			return found[i].LastSeen.Before(found[j].LastSeen)
+		}
+		return found[i].key() < found[j].key()
+	})
+	return found
+}
+
+func matchRef(ref, regarding corev1.ObjectReference) bool {
+	return (ref.Kind == "" || ref.Kind == regarding.Kind) &&
+		ref.Namespace == regarding.Namespace &&
+		(ref.Name == "" || ref.Name == regarding.Name)
+}
+
+// ForDeployment returns the events of a Deployment, its ReplicaSets and their
+// Pods seen within window.
+func (c *Collector) ForDeployment(ctx context.Context, namespace, name string, window time.Duration) ([]Event, error) {
+	deploy, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("get deployment failed", "namespace", namespace, "name", name, "error", err)
+		return nil, err
+	}
+	refs := []corev1.ObjectReference{{Kind: "Deployment", Namespace: namespace, Name: name}}
+
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		slog.Error("list replicasets failed", "namespace", namespace, "error", err)
+		return nil, err
+	}
+	owned := map[string]bool{}
+	for _, rs := range replicaSets.Items {
+		if ownedBy(rs.OwnerReferences, string(deploy.UID)) {
+			owned[string(rs.UID)] = true
+			refs = append(refs, corev1.ObjectReference{Kind: "ReplicaSet", Namespace: namespace, Name: rs.Name})
+		}
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		slog.Error("list pods failed", "namespace", namespace, "error", err)
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		for uid := range owned {
+			if ownedBy(pod.OwnerReferences, uid) {
+				refs = append(refs, corev1.ObjectReference{Kind: "Pod", Namespace: namespace, Name: pod.Name})
+				break
+			}
+		}
+	}
+	return c.Query(time.Now().Add(-window), refs...), nil
+}
+
+func ownedBy(refs []metav1.OwnerReference, uid string) bool {
+	for _, ref := range refs {
+		if string(ref.UID) == uid {
+			return true
+		}
+	}
+	return false
+}
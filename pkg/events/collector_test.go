@@ -0,0 +1,143 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func objectRef(kind, name string) corev1.ObjectReference {
+	return corev1.ObjectReference{Kind: kind, Namespace: "shop", Name: name, UID: k8stypes.UID("uid-" + name)}
+}
+
+func coreEvent(name string, regarding corev1.ObjectReference, reason string, count int32, last time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "shop", Name: name},
+		InvolvedObject: regarding,
+		Type:           corev1.EventTypeNormal,
+		Reason:         reason,
+		Message:        reason + " " + regarding.Name,
+		Count:          count,
+		FirstTimestamp: metav1.NewTime(last.Add(-time.Minute)),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+func TestCollectorAddDedupAndEvict(t *testing.T) {
+	now := time.Now()
+	c := NewCollector(fake.NewClientset(), 2)
+	pod := objectRef("Pod", "web-1")
+	backoff := Event{Type: "Warning", Reason: "BackOff", Note: "restarting", Regarding: pod, LastSeen: now, Count: 3}
+
+	c.Add("shop/web-1.a", backoff)
+	// The same Event object seen again through the other API
+	backoff.Count = 4
+	c.Add("shop/web-1.a", backoff)
+	// A recreated Event object continuing the series
+	backoff.Count, backoff.LastSeen = 2, now.Add(time.Minute)
+	c.Add("shop/web-1.b", backoff)
+
+	got := c.Query(time.Time{}, pod)
+	if len(got) != 1 || got[0].Count != 6 || !got[0].LastSeen.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Query() got = %+v, want one series counted 6", got)
+	}
+
+	c.Add("shop/web-1.c", Event{Reason: "Pulled", Regarding: pod, LastSeen: now})
+	c.Add("shop/web-1.d", Event{Reason: "Started", Regarding: pod, LastSeen: now})
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+	for _, e := range c.Query(time.Time{}, pod) {
+		if e.Reason == "BackOff" {
+			t.Errorf("Query() kept evicted series %+v", e)
+		}
+	}
+}
+
+func TestCollectorForDeployment(t *testing.T) {
+	now := time.Now()
+	deploy := objectRef("Deployment", "web")
+	rs := objectRef("ReplicaSet", "web-6f7c")
+	pod := objectRef("Pod", "web-6f7c-abcde")
+	other := objectRef("Pod", "api-1")
+
+	clientset := fake.NewClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web", UID: deploy.UID}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "shop", Name: rs.Name, UID: rs.UID,
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", UID: deploy.UID}},
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "shop", Name: pod.Name, UID: pod.UID,
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID}},
+		}},
+		coreEvent("web.1", deploy, "ScalingReplicaSet", 1, now.Add(-10*time.Minute)),
+		coreEvent("web-6f7c.1", rs, "SuccessfulCreate", 1, now.Add(-9*time.Minute)),
+		coreEvent("web-6f7c-abcde.1", pod, "Pulled", 2, now.Add(-8*time.Minute)),
+		coreEvent("web-6f7c-abcde.0", pod, "Scheduled", 1, now.Add(-2*time.Hour)),
+		coreEvent("api-1.1", other, "Pulled", 1, now.Add(-time.Minute)),
+		&eventsv1.Event{
+			ObjectMeta:          metav1.ObjectMeta{Namespace: "shop", Name: "web-6f7c-abcde.1"},
+			Regarding:           pod,
+			Type:                corev1.EventTypeNormal,
+			Reason:              "Pulled",
+			Note:                "Pulled " + pod.Name,
+			EventTime:           metav1.NewMicroTime(now.Add(-8 * time.Minute)),
+			ReportingController: "kubelet",
+			Series:              &eventsv1.EventSeries{Count: 2, LastObservedTime: metav1.NewMicroTime(now.Add(-8 * time.Minute))},
+		},
+	)
+
+	c := NewCollector(clientset, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return c.Len() == 5, nil
+	})
+	if err != nil {
+		t.Fatalf("Run() collected %d series, want 5", c.Len())
+	}
+
+	got, err := c.ForDeployment(ctx, "shop", "web", 30*time.Minute)
+	if err != nil {
+		t.Fatalf("ForDeployment() error = %v", err)
+	}
+	reasons := []string{}
+	for _, e := range got {
+		reasons = append(reasons, e.Regarding.Kind+"/"+e.Reason)
+	}
+	want := "Deployment/ScalingReplicaSet ReplicaSet/SuccessfulCreate Pod/Pulled"
+	if strings.Join(reasons, " ") != want {
+		t.Fatalf("ForDeployment() got = %v, want %s", reasons, want)
+	}
+	if got[2].Count != 2 {
+		t.Errorf("ForDeployment() pulled count = %d, want 2 for the event seen through both APIs", got[2].Count)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTimeline(buf, got); err != nil {
+		t.Fatalf("WriteTimeline() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[1], "+0s") || !strings.Contains(lines[3], "+2m0s") {
+		t.Errorf("WriteTimeline() = %s", buf.String())
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
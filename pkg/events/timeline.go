@@ -0,0 +1,25 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteTimeline renders events as one line per series, oldest first, with the
+// offset from the first event so bursts stand out.
+func WriteTimeline(w io.Writer, events []Event) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOFFSET\tTYPE\tOBJECT\tREASON\tCOUNT\tMESSAGE")
+	var start time.Time
+	for i, e := range events {
+		if i == 0 {
+			start = e.LastSeen
+		}
+		fmt.Fprintf(tw, "%s\t+%s\t%s\t%s/%s\t%s\t%d\t%s\n",
+			e.LastSeen.Format(time.TimeOnly), e.LastSeen.Sub(start).Truncate(time.Second), e.Type,
+			e.Regarding.Kind, e.Regarding.Name, e.Reason, e.Count, e.Note)
+	}
+	return tw.Flush()
+}
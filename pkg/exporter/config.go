@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/urans/kubemaze/pkg/tour"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Config lists the exported metrics
+type Config struct {
+	Metrics []MetricConfig `json:"metrics"`
+}
+
+// MetricConfig defines a gauge over the objects of a resource. The values of
+// the objects having the same labels add up, so a value of 1 counts them.
+type MetricConfig struct {
+	Name string `json:"name"`
+	Help string `json:"help,omitempty"`
+	// Resource is a built-in resource like pods or nodes, or a fully specified
+	// custom resource like memcacheds.v1.cache.urans.com.
+	Resource string `json:"resource"`
+	// Labels maps the label names to CEL expressions of their values.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Value is a CEL expression evaluating to a number or a bool, 1 when empty.
+	Value string `json:"value,omitempty"`
+}
+
+// DefaultConfig exports pods by phase, node readiness, kubelet versions and
+// roles, and whether the Memcached instances are available
+func DefaultConfig() *Config {
+	return &Config{Metrics: []MetricConfig{
+		{
+			Name:     "kubemaze_pods",
+			Help:     "Pods by namespace and phase.",
+			Resource: "pods",
+			Labels:   map[string]string{"namespace": "object.metadata.namespace", "phase": `object.status.?phase.orValue("Unknown")`},
+		},
+		{
+			Name:     "kubemaze_node_ready",
+			Help:     "Whether the node is ready.",
+			Resource: "nodes",
+			Labels:   map[string]string{"node": "object.metadata.name"},
+			Value:    "isReady(object)",
+		},
+		{
+			Name:     "kubemaze_node_kubelet_version",
+			Help:     "Kubelet version of the node.",
+			Resource: "nodes",
+			Labels:   map[string]string{"node": "object.metadata.name", "version": "kubeletVersion(object)"},
+		},
+		{
+			Name:     "kubemaze_nodes",
+			Help:     "Nodes by role.",
+			Resource: "nodes",
+			Labels:   map[string]string{"role": `isMaster(object) ? "master" : "worker"`},
+		},
+		{
+			Name:     "kubemaze_memcached_available",
+			Help:     "Whether the Memcached instance has the Available condition.",
+			Resource: "memcacheds.v1.cache.urans.com",
+			Labels:   map[string]string{"namespace": "object.metadata.namespace", "name": "object.metadata.name"},
+			Value:    `object.?status.?conditions.orValue([]).exists(c, c.type == "Available" && c.status == "True")`,
+		},
+	}}
+}
+
+// LoadConfig reads a YAML or JSON metrics config
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// exprEnv declares the object variable of the expressions and the node
+// functions isReady, isMaster and kubeletVersion
+var exprEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.OptionalTypes(),
+		nodeFunction("isReady", cel.BoolType, func(node *corev1.Node) ref.Val { return types.Bool(tour.IsReady(node)) }),
+		nodeFunction("isMaster", cel.BoolType, func(node *corev1.Node) ref.Val { return types.Bool(tour.IsMaster(node)) }),
+		nodeFunction("kubeletVersion", cel.StringType, func(node *corev1.Node) ref.Val { return types.String(tour.KubeletVersion(node)) }),
+	)
+})
+
+// nodeFunction declares a function of a node object
+func nodeFunction(name string, result *cel.Type, fn func(*corev1.Node) ref.Val) cel.EnvOption {
+	return cel.Function(name, cel.Overload(name+"_node", []*cel.Type{cel.DynType}, result,
+		cel.UnaryBinding(func(arg ref.Val) ref.Val {
+			object, ok := arg.Value().(map[string]any)
+			if !ok {
+				return types.NewErr("%s: want a node, got %s", name, arg.Type())
+			}
+			node := &corev1.Node{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, node); err != nil {
+				return types.NewErr("%s: %v", name, err)
+			}
+			return fn(node)
+		}),
+	))
+}
+
+// compile checks an expression evaluates to one of the wanted types
+func compile(expr string, want ...*cel.Type) (cel.Program, error) {
+	env, err := exprEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, issues.Err())
+	}
+	if t := ast.OutputType(); t != cel.DynType && !slices.ContainsFunc(want, func(w *cel.Type) bool { return w.IsExactType(t) }) {
+		return nil, fmt.Errorf("invalid expression %q: evaluates to %s, want %v", expr, t, want)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	return program, nil
+}
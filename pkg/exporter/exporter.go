@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urans/kubemaze/pkg/informer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+type metric struct {
+	name   string
+	desc   *prometheus.Desc
+	lister cache.GenericLister
+	// labels are the label names, sorted, and the programs of their values.
+	labels   []string
+	programs []cel.Program
+	value    cel.Program
+}
+
+// Exporter collects gauges of the objects cached by shared informers
+type Exporter struct {
+	metrics []*metric
+}
+
+// New creates the informers of the configured resources on factory, or on
+// dynamicFactory for the custom resources. Start the factories before
+// collecting.
+func New(cfg *Config, factory informers.SharedInformerFactory, dynamicFactory dynamicinformer.DynamicSharedInformerFactory) (*Exporter, error) {
+	e := &Exporter{}
+	for _, mc := range cfg.Metrics {
+		m, err := newMetric(mc, factory, dynamicFactory)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", mc.Name, err)
+		}
+		e.metrics = append(e.metrics, m)
+	}
+	return e, nil
+}
+
+func newMetric(mc MetricConfig, factory informers.SharedInformerFactory, dynamicFactory dynamicinformer.DynamicSharedInformerFactory) (*metric, error) {
+	if mc.Name == "" || mc.Resource == "" {
+		return nil, errors.New("name and resource are required")
+	}
+	m := &metric{name: mc.Name, labels: slices.Sorted(maps.Keys(mc.Labels))}
+	if r, err := informer.ResolveResource(mc.Resource); err == nil {
+		generic, err := factory.ForResource(r.GroupVersionResource)
+		if err != nil {
+			return nil, err
+		}
+		m.lister = generic.Lister()
+	} else if gvr, _ := schema.ParseResourceArg(mc.Resource); gvr != nil {
+		m.lister = dynamicFactory.ForResource(*gvr).Lister()
+	} else {
+		return nil, fmt.Errorf("resource %q is neither built-in nor like resource.version.group", mc.Resource)
+	}
+	for _, label := range m.labels {
+		program, err := compile(mc.Labels[label], cel.StringType)
+		if err != nil {
+			return nil, fmt.Errorf("label %s: %w", label, err)
+		}
+		m.programs = append(m.programs, program)
+	}
+	value := mc.Value
+	if value == "" {
+		value = "1"
+	}
+	program, err := compile(value, cel.BoolType, cel.IntType, cel.UintType, cel.DoubleType)
+	if err != nil {
+		return nil, fmt.Errorf("value: %w", err)
+	}
+	m.value = program
+	help := mc.Help
+	if help == "" {
+		help = fmt.Sprintf("Objects of %s.", mc.Resource)
+	}
+	m.desc = prometheus.NewDesc(mc.Name, help, m.labels, nil)
+	return m, nil
+}
+
+// Describe sends the descriptors of the metrics
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range e.metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect evaluates the metrics on the cached objects. An object failing to
+// evaluate is left out.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range e.metrics {
+		objects, err := m.lister.List(labels.Everything())
+		if err != nil {
+			slog.Error("list objects failed", "metric", m.name, "error", err)
+			continue
+		}
+		values := map[string]float64{}
+		for _, obj := range objects {
+			labelValues, value, err := m.evaluate(obj)
+			if err != nil {
+				slog.Debug("evaluate metric failed", "metric", m.name, "error", err)
+				continue
+			}
+			values[strings.Join(labelValues, "\xff")] += value
+		}
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			var labelValues []string
+			if len(m.labels) > 0 {
+				labelValues = strings.Split(key, "\xff")
+			}
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, values[key], labelValues...)
+		}
+	}
+}
+
+func (m *metric) evaluate(obj runtime.Object) ([]string, float64, error) {
+	var object map[string]any
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		object = u.Object
+	} else {
+		var err error
+		if object, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return nil, 0, err
+		}
+	}
+	vars := map[string]any{"object": object}
+	labelValues := make([]string, 0, len(m.programs))
+	for i, program := range m.programs {
+		out, _, err := program.Eval(vars)
+		if err != nil {
+			return nil, 0, fmt.Errorf("label %s: %w", m.labels[i], err)
+		}
+		labelValues = append(labelValues, fmt.Sprint(out.Value()))
+	}
+	out, _, err := m.value.Eval(vars)
+	if err != nil {
+		return nil, 0, fmt.Errorf("value: %w", err)
+	}
+	switch v := out.Value().(type) {
+	case bool:
+		if v {
+			return labelValues, 1, nil
+		}
+		return labelValues, 0, nil
+	case int64:
+		return labelValues, float64(v), nil
+	case uint64:
+		return labelValues, float64(v), nil
+	case float64:
+		return labelValues, v, nil
+	}
+	return nil, 0, fmt.Errorf("value evaluated to %v, want a number or a bool", out.Value())
+}
@@ -0,0 +1,192 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var memcachedsGVR = schema.GroupVersionResource{Group: "cache.urans.com", Version: "v1", Resource: "memcacheds"}
+
+func exporterNode(name, version string, master, ready bool) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	if master {
+		node.Labels["node-role.kubernetes.io/master"] = ""
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+	node.Status.NodeInfo.KubeletVersion = version
+	return node
+}
+
+func exporterPod(namespace, name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Status: corev1.PodStatus{Phase: phase}}
+}
+
+func memcached(name string, available string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "cache.urans.com/v1",
+		"kind":       "Memcached",
+		"metadata":   map[string]any{"namespace": "cache", "name": name},
+	}}
+	if available != "" {
+		obj.Object["status"] = map[string]any{"conditions": []any{
+			map[string]any{"type": "Available", "status": available},
+		}}
+	}
+	return obj
+}
+
+func startExporter(t *testing.T, cfg *Config) *prometheus.Registry {
+	clientset := fake.NewClientset(
+		exporterNode("master-1", "v1.36.2", true, true),
+		exporterNode("node-1", "v1.36.2", false, true),
+		exporterNode("node-2", "v1.35.4", false, false),
+		exporterPod("shop", "web-1", corev1.PodRunning),
+		exporterPod("shop", "web-2", corev1.PodRunning),
+		exporterPod("shop", "web-3", corev1.PodPending),
+		exporterPod("blog", "web", corev1.PodFailed),
+	)
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{memcachedsGVR: "MemcachedList"},
+		memcached("sessions", "True"), memcached("pages", "False"), memcached("new", ""),
+	)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, 0)
+	e, err := New(cfg, factory, dynamicFactory)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		factory.Shutdown()
+		dynamicFactory.Shutdown()
+	})
+	factory.Start(ctx.Done())
+	dynamicFactory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	dynamicFactory.WaitForCacheSync(ctx.Done())
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(e); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return reg
+}
+
+func TestExporter(t *testing.T) {
+	reg := startExporter(t, DefaultConfig())
+	want := `
+# HELP kubemaze_memcached_available Whether the Memcached instance has the Available condition.
+# TYPE kubemaze_memcached_available gauge
+kubemaze_memcached_available{name="new",namespace="cache"} 0
+kubemaze_memcached_available{name="pages",namespace="cache"} 0
+kubemaze_memcached_available{name="sessions",namespace="cache"} 1
+# HELP kubemaze_node_kubelet_version Kubelet version of the node.
+# TYPE kubemaze_node_kubelet_version gauge
+kubemaze_node_kubelet_version{node="master-1",version="v1.36.2"} 1
+kubemaze_node_kubelet_version{node="node-1",version="v1.36.2"} 1
+kubemaze_node_kubelet_version{node="node-2",version="v1.35.4"} 1
+# HELP kubemaze_node_ready Whether the node is ready.
+# TYPE kubemaze_node_ready gauge
+kubemaze_node_ready{node="master-1"} 1
+kubemaze_node_ready{node="node-1"} 1
+kubemaze_node_ready{node="node-2"} 0
+# HELP kubemaze_nodes Nodes by role.
+# TYPE kubemaze_nodes gauge
+kubemaze_nodes{role="master"} 1
+kubemaze_nodes{role="worker"} 2
+# HELP kubemaze_pods Pods by namespace and phase.
+# TYPE kubemaze_pods gauge
+kubemaze_pods{namespace="blog",phase="Failed"} 1
+kubemaze_pods{namespace="shop",phase="Pending"} 1
+kubemaze_pods{namespace="shop",phase="Running"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.yaml")
+	config := `
+metrics:
+- name: shop_ready_nodes
+  resource: nodes
+  labels:
+    version: kubeletVersion(object)
+  value: isReady(object)
+- name: shop_pods
+  resource: pods
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	reg := startExporter(t, cfg)
+	want := `
+# HELP shop_pods Objects of pods.
+# TYPE shop_pods gauge
+shop_pods 4
+# HELP shop_ready_nodes Objects of nodes.
+# TYPE shop_ready_nodes gauge
+shop_ready_nodes{version="v1.35.4"} 0
+shop_ready_nodes{version="v1.36.2"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(path, []byte("metrics:\n- name: x\n  resource: pods\n  unknown: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("LoadConfig() unknown field error = nil")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric MetricConfig
+		want   string
+	}{
+		{name: "no resource", metric: MetricConfig{Name: "x"}, want: "name and resource are required"},
+		{name: "unknown resource", metric: MetricConfig{Name: "x", Resource: "widgets"}, want: "neither built-in"},
+		{name: "bad label", metric: MetricConfig{Name: "x", Resource: "pods", Labels: map[string]string{"phase": "object.status.phase +"}}, want: "label phase"},
+		{name: "label type", metric: MetricConfig{Name: "x", Resource: "pods", Labels: map[string]string{"ready": "1 == 1"}}, want: "want"},
+		{name: "value type", metric: MetricConfig{Name: "x", Resource: "pods", Value: `"one"`}, want: "value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := informers.NewSharedInformerFactory(fake.NewClientset(), 0)
+			dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+			_, err := New(&Config{Metrics: []MetricConfig{tt.metric}}, factory, dynamicFactory)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
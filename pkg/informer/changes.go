@@ -0,0 +1,152 @@
+package informer
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+// Change is an informer notification with what changed on update
+type Change struct {
+	Type      watch.EventType
+	Kind      string
+	Namespace string
+	Name      string
+	Summary   []string
+}
+
+// NewHandler reports the notifications of a source to report, skipping the
+// updates of resyncs which carry an unchanged resourceVersion
+func NewHandler(source Source, report func(Change)) cache.ResourceEventHandler {
+	notify := func(typ watch.EventType, obj any, summary []string) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		accessor, ok := obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		report(Change{Type: typ, Kind: source.Resource.Kind, Namespace: accessor.GetNamespace(), Name: accessor.GetName(), Summary: summary})
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) { notify(watch.Added, obj, nil) },
+		UpdateFunc: func(oldObj, newObj any) {
+			old, okOld := oldObj.(metav1.Object)
+			cur, okNew := newObj.(metav1.Object)
+			if okOld && okNew && old.GetResourceVersion() == cur.GetResourceVersion() {
+				return
+			}
+			notify(watch.Modified, newObj, Summarize(oldObj, newObj))
+		},
+		DeleteFunc: func(obj any) { notify(watch.Deleted, obj, nil) },
+	}
+}
+
+// Summarize describes what changed between two versions of an object. Pods
+// report phase, restarts, readiness and node, deployments their replicas, other
+// objects the changed fields.
+func Summarize(oldObj, newObj any) []string {
+	switch cur := newObj.(type) {
+	case *corev1.Pod:
+		if old, ok := oldObj.(*corev1.Pod); ok {
+			return summarizePod(old, cur)
+		}
+	case *appsv1.Deployment:
+		if old, ok := oldObj.(*appsv1.Deployment); ok {
+			return summarizeDeployment(old, cur)
+		}
+	}
+	return summarizeFields(oldObj, newObj)
+}
+
+func summarizePod(old, cur *corev1.Pod) []string {
+	var summary []string
+	if old.Status.Phase != cur.Status.Phase {
+		summary = append(summary, fmt.Sprintf("phase %s -> %s", orNone(string(old.Status.Phase)), cur.Status.Phase))
+	}
+	if old.Spec.NodeName != cur.Spec.NodeName {
+		summary = append(summary, fmt.Sprintf("node %s -> %s", orNone(old.Spec.NodeName), orNone(cur.Spec.NodeName)))
+	}
+	if oldReady, ready := podReady(old), podReady(cur); oldReady != ready {
+		summary = append(summary, fmt.Sprintf("ready %t -> %t", oldReady, ready))
+	}
+	restarts := map[string]int32{}
+	for _, status := range old.Status.ContainerStatuses {
+		restarts[status.Name] = status.RestartCount
+	}
+	for _, status := range cur.Status.ContainerStatuses {
+		if n := status.RestartCount - restarts[status.Name]; n > 0 {
+			reason := ""
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason != "" {
+				reason = " (" + terminated.Reason + ")"
+			}
+			summary = append(summary, fmt.Sprintf("container %s restarted %d times, %d in total%s", status.Name, n, status.RestartCount, reason))
+		}
+	}
+	return summary
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func summarizeDeployment(old, cur *appsv1.Deployment) []string {
+	var summary []string
+	replicas := func(r *int32) string {
+		if r == nil {
+			return "1"
+		}
+		return strconv.Itoa(int(*r))
+	}
+	if a, b := replicas(old.Spec.Replicas), replicas(cur.Spec.Replicas); a != b {
+		summary = append(summary, fmt.Sprintf("replicas %s -> %s", a, b))
+	}
+	if old.Status.ReadyReplicas != cur.Status.ReadyReplicas {
+		summary = append(summary, fmt.Sprintf("ready replicas %d -> %d", old.Status.ReadyReplicas, cur.Status.ReadyReplicas))
+	}
+	if old.Generation != cur.Generation {
+		summary = append(summary, fmt.Sprintf("generation %d -> %d", old.Generation, cur.Generation))
+	}
+	return summary
+}
+
+// summarizeFields lists the changed paths, leaving out the ones changing on every update
+func summarizeFields(oldObj, newObj any) []string {
+	old, errOld := toUnstructured(oldObj)
+	cur, errNew := toUnstructured(newObj)
+	if errOld != nil || errNew != nil {
+		return nil
+	}
+	var summary []string
+	for _, change := range jsondiff.Diff(old, cur, jsondiff.DefaultIgnored) {
+		summary = append(summary, change.Op+" "+change.Path)
+	}
+	return summary
+}
+
+func toUnstructured(obj any) (map[string]any, error) {
+	if obj, ok := obj.(runtime.Object); ok {
+		return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	}
+	return nil, fmt.Errorf("%T is not an object", obj)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
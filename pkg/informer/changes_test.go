@@ -0,0 +1,73 @@
+package informer
+
+import (
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func summaryPod(phase corev1.PodPhase, node string, ready bool, restarts int32) *corev1.Pod {
+	pod := informerPod("shop", "web", "1", phase)
+	pod.Spec.NodeName = node
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "web", RestartCount: restarts}}
+	if restarts > 0 {
+		pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: "OOMKilled"}
+	}
+	return pod
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name string
+		old  any
+		cur  any
+		want []string
+	}{
+		{
+			name: "pod scheduled",
+			old:  summaryPod("", "", false, 0),
+			cur:  summaryPod(corev1.PodPending, "node-1", false, 0),
+			want: []string{"phase <none> -> Pending", "node <none> -> node-1"},
+		},
+		{
+			name: "pod ready",
+			old:  summaryPod(corev1.PodPending, "node-1", false, 0),
+			cur:  summaryPod(corev1.PodRunning, "node-1", true, 0),
+			want: []string{"phase Pending -> Running", "ready false -> true"},
+		},
+		{
+			name: "pod restarted",
+			old:  summaryPod(corev1.PodRunning, "node-1", true, 1),
+			cur:  summaryPod(corev1.PodRunning, "node-1", false, 3),
+			want: []string{"ready true -> false", "container web restarted 2 times, 3 in total (OOMKilled)"},
+		},
+		{
+			name: "deployment scaled",
+			old:  &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](1)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 1}},
+			cur:  &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: ptr.To[int32](3)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 2}},
+			want: []string{"replicas 1 -> 3", "ready replicas 1 -> 2"},
+		},
+		{
+			name: "configmap fields",
+			old:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"}, Data: map[string]string{"a": "1", "b": "2"}},
+			cur:  &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "2"}, Data: map[string]string{"a": "2", "c": "3"}},
+			want: []string{"replace /data/a", "remove /data/b", "add /data/c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Summarize(tt.old, tt.cur); !slices.Equal(got, tt.want) {
+				t.Errorf("Summarize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package informer
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DashboardRow counts the cached objects of a resource in a namespace
+type DashboardRow struct {
+	Kind      string
+	Namespace string
+	Count     int
+	// Phases counts pods by phase.
+	Phases map[string]int
+}
+
+// Dashboard counts the cached objects of every source per namespace
+func (i *Informers) Dashboard() []DashboardRow {
+	rows := map[string]*DashboardRow{}
+	for _, s := range i.sources {
+		for _, obj := range s.Informer.GetStore().List() {
+			accessor, ok := obj.(metav1.Object)
+			if !ok {
+				continue
+			}
+			key := s.Resource.Kind + "/" + accessor.GetNamespace()
+			row := rows[key]
+			if row == nil {
+				row = &DashboardRow{Kind: s.Resource.Kind, Namespace: accessor.GetNamespace()}
+				rows[key] = row
+			}
+			row.Count++
+			if pod, ok := obj.(*corev1.Pod); ok {
+				if row.Phases == nil {
+					row.Phases = map[string]int{}
+				}
+				row.Phases[string(pod.Status.Phase)]++
+			}
+		}
+	}
+	result := make([]DashboardRow, 0, len(rows))
+	for _, key := range slices.Sorted(maps.Keys(rows)) {
+		result = append(result, *rows[key])
+	}
+	return result
+}
+
+// WriteDashboard prints the dashboard as a table
+func (i *Informers) WriteDashboard(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAMESPACE\tCOUNT\tPHASES")
+	for _, row := range i.Dashboard() {
+		var phases []string
+		for _, phase := range slices.Sorted(maps.Keys(row.Phases)) {
+			phases = append(phases, fmt.Sprintf("%s=%d", phase, row.Phases[phase]))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", row.Kind, orDash(row.Namespace), row.Count, orDash(strings.Join(phases, " ")))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,149 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Resource is a built-in resource the shared informer factory can watch
+type Resource struct {
+	schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+}
+
+// builtinResources are the resources accepted by name, like pods or deployments.apps
+var builtinResources = []Resource{
+	{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "Pod", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "services"}, "Service", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, "Endpoints", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, "ConfigMap", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, "Secret", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, "PersistentVolumeClaim", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "events"}, "Event", true},
+	{schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, "Node", false},
+	{schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "Namespace", false},
+	{schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}, "PersistentVolume", false},
+	{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "Deployment", true},
+	{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, "ReplicaSet", true},
+	{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, "StatefulSet", true},
+	{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, "DaemonSet", true},
+	{schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, "Job", true},
+	{schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, "CronJob", true},
+	{schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, "Ingress", true},
+}
+
+// ResolveResource finds a built-in resource by name, like pods, deployments.apps
+// or jobs.v1.batch
+func ResolveResource(arg string) (Resource, error) {
+	fullySpecified, groupResource := schema.ParseResourceArg(strings.ToLower(arg))
+	for _, r := range builtinResources {
+		if fullySpecified != nil && r.GroupVersionResource == *fullySpecified {
+			return r, nil
+		}
+		if r.GroupResource() == groupResource {
+			return r, nil
+		}
+	}
+	return Resource{}, fmt.Errorf("unsupported resource %q", arg)
+}
+
+// Source is the informer of a resource in a namespace, empty for all namespaces
+// and cluster-scoped resources
+type Source struct {
+	Resource  Resource
+	Namespace string
+	Informer  cache.SharedIndexInformer
+}
+
+// Informers runs shared informers of several resources in several namespaces
+type Informers struct {
+	factories map[string]informers.SharedInformerFactory
+	sources   []Source
+}
+
+// New creates the informers of resources, resyncing every resync. Namespaced
+// resources get an informer per namespace, all namespaces when none is given.
+func New(clientset kubernetes.Interface, resources, namespaces []string, resync time.Duration) (*Informers, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	i := &Informers{factories: map[string]informers.SharedInformerFactory{}}
+	factory := func(namespace string) informers.SharedInformerFactory {
+		if i.factories[namespace] == nil {
+			i.factories[namespace] = informers.NewSharedInformerFactoryWithOptions(clientset, resync, informers.WithNamespace(namespace))
+		}
+		return i.factories[namespace]
+	}
+	for _, ns := range namespaces {
+		factory(ns)
+	}
+	for _, arg := range resources {
+		r, err := ResolveResource(arg)
+		if err != nil {
+			return nil, err
+		}
+		scopes := namespaces
+		if !r.Namespaced {
+			scopes = []string{""}
+		}
+		for _, ns := range scopes {
+			generic, err := factory(ns).ForResource(r.GroupVersionResource)
+			if err != nil {
+				return nil, err
+			}
+			i.sources = append(i.sources, Source{Resource: r, Namespace: ns, Informer: generic.Informer()})
+		}
+	}
+	return i, nil
+}
+
+// Sources returns the informers
+func (i *Informers) Sources() []Source {
+	return i.sources
+}
+
+// Factory returns the factory of a namespace, empty for all namespaces, creating
+// informers on it shares them with the sources
+func (i *Informers) Factory(namespace string) informers.SharedInformerFactory {
+	return i.factories[namespace]
+}
+
+// AddEventHandler registers a handler built per source
+func (i *Informers) AddEventHandler(handler func(Source) cache.ResourceEventHandler) error {
+	for _, s := range i.sources {
+		if _, err := s.Informer.AddEventHandler(handler(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start runs the informers until ctx is done and waits for their caches to sync
+func (i *Informers) Start(ctx context.Context) error {
+	for _, f := range i.factories {
+		f.Start(ctx.Done())
+	}
+	for _, f := range i.factories {
+		for typ, synced := range f.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("cache of %v not synced", typ)
+			}
+		}
+	}
+	return nil
+}
+
+// Shutdown waits for the informers to stop once ctx is done
+func (i *Informers) Shutdown() {
+	for _, f := range i.factories {
+		f.Shutdown()
+	}
+}
@@ -0,0 +1,142 @@
+package informer
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestResolveResource(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    string
+		wantErr bool
+	}{
+		{arg: "pods", want: "Pod"},
+		{arg: "Deployments.apps", want: "Deployment"},
+		{arg: "jobs.v1.batch", want: "Job"},
+		{arg: "nodes", want: "Node"},
+		{arg: "deployments", wantErr: true},
+		{arg: "memcacheds.cache.urans.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			r, err := ResolveResource(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveResource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if r.Kind != tt.want {
+				t.Errorf("ResolveResource() = %s, want %s", r.Kind, tt.want)
+			}
+		})
+	}
+}
+
+func informerPod(namespace, name, rv string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: rv},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestInformers(t *testing.T) {
+	clientset := fake.NewClientset(
+		informerPod("shop", "web", "1", corev1.PodRunning),
+		informerPod("shop", "api", "1", corev1.PodPending),
+		informerPod("blog", "web", "1", corev1.PodRunning),
+		informerPod("kube-system", "dns", "1", corev1.PodRunning),
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+	i, err := New(clientset, []string{"pods", "nodes"}, []string{"shop", "blog"}, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := len(i.Sources()); got != 3 {
+		t.Errorf("Sources() = %d, want pods in two namespaces and nodes", got)
+	}
+	if _, err := New(clientset, []string{"widgets"}, nil, time.Hour); err == nil {
+		t.Errorf("New() unknown resource error = nil")
+	}
+
+	var mu sync.Mutex
+	var changes []Change
+	err = i.AddEventHandler(func(s Source) cache.ResourceEventHandler {
+		return NewHandler(s, func(c Change) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, c)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer func() {
+		cancel()
+		i.Shutdown()
+	}()
+	if err := i.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := i.WriteDashboard(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "KIND  NAMESPACE  COUNT  PHASES\n" +
+		"Node  -          1      -\n" +
+		"Pod   blog       1      Running=1\n" +
+		"Pod   shop       2      Pending=1 Running=1\n"
+	if buf.String() != want {
+		t.Errorf("WriteDashboard() =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// A resync delivers the cached object as its own update, which is skipped
+	for _, s := range i.Sources() {
+		if s.Resource.Kind != "Pod" || s.Namespace != "shop" {
+			continue
+		}
+		handler := NewHandler(s, func(c Change) { t.Errorf("handler reported resync %+v", c) })
+		pod := informerPod("shop", "web", "1", corev1.PodRunning)
+		handler.OnUpdate(pod, pod)
+	}
+
+	if _, err := clientset.CoreV1().Pods("shop").Update(ctx, informerPod("shop", "api", "2", corev1.PodRunning), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// The informers deliver on their own, the adds of the others may come later
+	deadline := time.After(5 * time.Second)
+	for {
+		mu.Lock()
+		count := len(changes)
+		i := slices.IndexFunc(changes, func(c Change) bool { return c.Type == watch.Modified })
+		update := Change{}
+		if i >= 0 {
+			update = changes[i]
+		}
+		mu.Unlock()
+		if i >= 0 && count == 5 {
+			if update.Name != "api" || len(update.Summary) != 1 || update.Summary[0] != "phase Pending -> Running" {
+				t.Errorf("update = %+v, want the api update", update)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			mu.Lock()
+			defer mu.Unlock()
+			t.Fatalf("update not reported, changes %+v, want 4 adds and the api update", changes)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package jsondiff
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultIgnored are the paths changing on every update without telling much
+var DefaultIgnored = []string{"/metadata/resourceVersion", "/metadata/managedFields", "/status"}
+
+// Change is a JSON Patch operation carrying the replaced or removed value too
+type Change struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+	Old   any    `json:"old,omitempty"`
+}
+
+// Diff compares two JSON objects and returns the changes turning old into
+// current, sorted by path. Changes at or below an ignored path are dropped.
+func Diff(old, current map[string]any, ignored []string) []Change {
+	d := differ{ignored: ignored}
+	d.diff("", old, current)
+	return d.changes
+}
+
+type differ struct {
+	ignored []string
+	changes []Change
+}
+
+func (d *differ) skip(path string) bool {
+	for _, ignored := range d.ignored {
+		if path == ignored || strings.HasPrefix(path, ignored+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *differ) diff(path string, old, current any) {
+	if d.skip(path) {
+		return
+	}
+	switch o := old.(type) {
+	case map[string]any:
+		if c, ok := current.(map[string]any); ok {
+			d.diffMaps(path, o, c)
+			return
+		}
+	case []any:
+		if c, ok := current.([]any); ok {
+			d.diffSlices(path, o, c)
+			return
+		}
+	}
+	if !reflect.DeepEqual(old, current) {
+		d.changes = append(d.changes, Change{Op: "replace", Path: path, Value: current, Old: old})
+	}
+}
+
+func (d *differ) diffMaps(path string, old, current map[string]any) {
+	keys := slices.Collect(maps.Keys(old))
+	for key := range current {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		child := path + "/" + escapePointer(key)
+		o, inOld := old[key]
+		c, inCurrent := current[key]
+		switch {
+		case d.skip(child):
+		case !inCurrent:
+			d.changes = append(d.changes, Change{Op: "remove", Path: child, Old: o})
+		case !inOld:
+			d.changes = append(d.changes, Change{Op: "add", Path: child, Value: c})
+		default:
+			d.diff(child, o, c)
+		}
+	}
+}
+
+func (d *differ) diffSlices(path string, old, current []any) {
+	for i := range min(len(old), len(current)) {
+		d.diff(path+"/"+strconv.Itoa(i), old[i], current[i])
+	}
+	for i := len(old); i < len(current); i++ {
+		d.changes = append(d.changes, Change{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: current[i]})
+	}
+	// Remove from the end so the indexes stay valid when applied as a patch
+	for i := len(old) - 1; i >= len(current); i-- {
+		d.changes = append(d.changes, Change{Op: "remove", Path: path + "/" + strconv.Itoa(i), Old: old[i]})
+	}
+}
+
+// escapePointer escapes a key as a JSON Pointer reference token
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,85 @@
+package jsondiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := map[string]any{
+		"metadata": map[string]any{
+			"name":            "web",
+			"resourceVersion": "1",
+			"labels":          map[string]any{"app": "web", "team/owner": "a"},
+			"managedFields":   []any{map[string]any{"manager": "kubectl"}},
+		},
+		"spec": map[string]any{
+			"replicas": int64(1),
+			"args":     []any{"-v", "-p", "80"},
+		},
+		"status": map[string]any{"readyReplicas": int64(1)},
+	}
+	current := map[string]any{
+		"metadata": map[string]any{
+			"name":            "web",
+			"resourceVersion": "2",
+			"labels":          map[string]any{"app": "web", "tier": "front"},
+			"managedFields":   []any{map[string]any{"manager": "helm"}},
+		},
+		"spec": map[string]any{
+			"replicas": int64(3),
+			"args":     []any{"-v"},
+		},
+		"status": map[string]any{"readyReplicas": int64(3)},
+	}
+
+	tests := []struct {
+		name    string
+		ignored []string
+		want    []Change
+	}{
+		{
+			name:    "default ignored",
+			ignored: DefaultIgnored,
+			want: []Change{
+				{Op: "remove", Path: "/metadata/labels/team~1owner", Old: "a"},
+				{Op: "add", Path: "/metadata/labels/tier", Value: "front"},
+				{Op: "remove", Path: "/spec/args/2", Old: "80"},
+				{Op: "remove", Path: "/spec/args/1", Old: "-p"},
+				{Op: "replace", Path: "/spec/replicas", Value: int64(3), Old: int64(1)},
+			},
+		},
+		{
+			name:    "status requested",
+			ignored: []string{"/metadata", "/spec"},
+			want: []Change{
+				{Op: "replace", Path: "/status/readyReplicas", Value: int64(3), Old: int64(1)},
+			},
+		},
+		{
+			name:    "nothing ignored",
+			ignored: nil,
+			want: []Change{
+				{Op: "remove", Path: "/metadata/labels/team~1owner", Old: "a"},
+				{Op: "add", Path: "/metadata/labels/tier", Value: "front"},
+				{Op: "replace", Path: "/metadata/managedFields/0/manager", Value: "helm", Old: "kubectl"},
+				{Op: "replace", Path: "/metadata/resourceVersion", Value: "2", Old: "1"},
+				{Op: "remove", Path: "/spec/args/2", Old: "80"},
+				{Op: "remove", Path: "/spec/args/1", Old: "-p"},
+				{Op: "replace", Path: "/spec/replicas", Value: int64(3), Old: int64(1)},
+				{Op: "replace", Path: "/status/readyReplicas", Value: int64(3), Old: int64(1)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(old, current, tt.ignored); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got := Diff(old, old, nil); got != nil {
+		t.Errorf("Diff() of equal objects = %+v, want none", got)
+	}
+}
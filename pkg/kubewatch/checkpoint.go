@@ -0,0 +1,208 @@
+package kubewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Checkpoint is the watch progress of a resource: the last processed
+// resourceVersion and the objects known at that point
+type Checkpoint struct {
+	ResourceVersion string `json:"resourceVersion"`
+	APIVersion      string `json:"apiVersion"`
+	Kind            string `json:"kind"`
+	// Objects maps namespace/name keys to resourceVersions.
+	Objects map[string]string `json:"objects"`
+}
+
+// Checkpoints are keyed by group resource, like deployments.apps
+type Checkpoints map[string]*Checkpoint
+
+func checkpointKey(gvr schema.GroupVersionResource) string {
+	return gvr.GroupResource().String()
+}
+
+// stubs rebuilds the known objects, carrying just enough to be diffed against a
+// relist and reported as deleted
+func (c *Checkpoint) stubs() []*unstructured.Unstructured {
+	objs := make([]*unstructured.Unstructured, 0, len(c.Objects))
+	for key, rv := range c.Objects {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: map[string]any{}}
+		obj.SetAPIVersion(c.APIVersion)
+		obj.SetKind(c.Kind)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetResourceVersion(rv)
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// CheckpointStore persists checkpoints across restarts
+type CheckpointStore interface {
+	Load(ctx context.Context) (Checkpoints, error)
+	Save(ctx context.Context, checkpoints Checkpoints) error
+}
+
+// NewCheckpointStore creates a store from a location, either a file path or
+// configmap:namespace/name for a ConfigMap
+func NewCheckpointStore(location string, clientset kubernetes.Interface) (CheckpointStore, error) {
+	ref, ok := strings.CutPrefix(location, "configmap:")
+	if !ok {
+		return &FileCheckpointStore{Path: location}, nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(ref)
+	if err != nil || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid checkpoint configmap %q, want configmap:namespace/name", location)
+	}
+	return &ConfigMapCheckpointStore{Clientset: clientset, Namespace: namespace, Name: name}, nil
+}
+
+// FileCheckpointStore keeps checkpoints in a local JSON file
+type FileCheckpointStore struct {
+	Path string
+}
+
+// Load reads the checkpoints, none when the file does not exist yet
+func (s *FileCheckpointStore) Load(_ context.Context) (Checkpoints, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return Checkpoints{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := Checkpoints{}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.Path, err)
+	}
+	return checkpoints, nil
+}
+
+// Save replaces the file atomically
+func (s *FileCheckpointStore) Save(_ context.Context, checkpoints Checkpoints) error {
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// checkpointsDataKey holds the checkpoints in the ConfigMap
+const checkpointsDataKey = "checkpoints.json"
+
+// maxConfigMapCheckpoints keeps the checkpoints under the 1MiB ConfigMap limit
+// with room for the metadata
+const maxConfigMapCheckpoints = 900 << 10
+
+// ConfigMapCheckpointStore keeps checkpoints in a ConfigMap, for running in-cluster
+type ConfigMapCheckpointStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load reads the checkpoints, none when the ConfigMap does not exist yet
+func (s *ConfigMapCheckpointStore) Load(ctx context.Context) (Checkpoints, error) {
+	cm, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if kerrs.IsNotFound(err) {
+		return Checkpoints{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := Checkpoints{}
+	if data, ok := cm.Data[checkpointsDataKey]; ok {
+		if err := json.Unmarshal([]byte(data), &checkpoints); err != nil {
+			return nil, fmt.Errorf("parse configmap %s/%s: %w", s.Namespace, s.Name, err)
+		}
+	}
+	return checkpoints, nil
+}
+
+// Save creates or updates the ConfigMap. When the checkpoints do not fit, the
+// objects of the largest resources are left out, their watches then resume from
+// the resourceVersion alone and miss the deletions while stopped.
+func (s *ConfigMapCheckpointStore) Save(ctx context.Context, checkpoints Checkpoints) error {
+	data, err := fitCheckpoints(checkpoints, maxConfigMapCheckpoints)
+	if err != nil {
+		return err
+	}
+	client := s.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	cm, err := client.Get(ctx, s.Name, metav1.GetOptions{})
+	if kerrs.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.Name},
+			Data:       map[string]string{checkpointsDataKey: string(data)},
+		}
+		_, err = client.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[checkpointsDataKey] = string(data)
+	_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// fitCheckpoints marshals the checkpoints, dropping the objects of the resources
+// knowing the most until the data is at most limit bytes
+func fitCheckpoints(checkpoints Checkpoints, limit int) ([]byte, error) {
+	data, err := json.Marshal(checkpoints)
+	if err != nil || len(data) <= limit {
+		return data, err
+	}
+	trimmed := make(Checkpoints, len(checkpoints))
+	keys := make([]string, 0, len(checkpoints))
+	for key, cp := range checkpoints {
+		copied := *cp
+		trimmed[key] = &copied
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(trimmed[keys[i]].Objects) > len(trimmed[keys[j]].Objects) })
+	for _, key := range keys {
+		if len(trimmed[key].Objects) == 0 {
+			break
+		}
+		slog.Warn("checkpoint too large, objects left out", "resource", key, "objects", len(trimmed[key].Objects), "size", len(data), "limit", limit)
+		trimmed[key].Objects = nil
+		if data, err = json.Marshal(trimmed); err != nil || len(data) <= limit {
+			return data, err
+		}
+	}
+	return nil, fmt.Errorf("checkpoints of %d bytes exceed %d bytes without objects", len(data), limit)
+}
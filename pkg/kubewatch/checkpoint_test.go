@@ -0,0 +1,206 @@
+package kubewatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestCheckpointStores(t *testing.T) {
+	clientset := fake.NewClientset()
+	fileStore, err := NewCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmStore, err := NewCheckpointStore("configmap:monitoring/kubewatch", clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCheckpointStore("configmap:kubewatch", clientset); err == nil {
+		t.Errorf("NewCheckpointStore() without namespace error = nil")
+	}
+
+	checkpoints := Checkpoints{
+		"deployments.apps": {ResourceVersion: "42", APIVersion: "apps/v1", Kind: "Deployment", Objects: map[string]string{"shop/web": "40"}},
+	}
+	for name, store := range map[string]CheckpointStore{"file": fileStore, "configmap": cmStore} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			got, err := store.Load(ctx)
+			if err != nil || len(got) != 0 {
+				t.Fatalf("Load() before save = %v, %v, want none", got, err)
+			}
+			// Saving twice updates what the first save created
+			for range 2 {
+				if err := store.Save(ctx, checkpoints); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			got, err = store.Load(ctx)
+			if err != nil || !reflect.DeepEqual(got, checkpoints) {
+				t.Errorf("Load() = %v, %v, want %v", got, err, checkpoints)
+			}
+		})
+	}
+}
+
+func TestWatcherResume(t *testing.T) {
+	store := &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoints.json")}
+	// Before the restart settings and gone existed
+	err := store.Save(context.Background(), Checkpoints{
+		"configmaps": {ResourceVersion: "3", APIVersion: "v1", Kind: "ConfigMap", Objects: map[string]string{"shop/settings": "1", "shop/gone": "2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disc := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	disc.Resources = []*metav1.APIResourceList{resourceList(configMapsGVR, "ConfigMap")}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{configMapsGVR: "ConfigMapList"},
+		watchObject(configMapsGVR, "ConfigMap", "settings", "1"),
+		watchObject(configMapsGVR, "ConfigMap", "flags", "4"),
+	)
+	// The checkpointed resourceVersion expired meanwhile
+	var watches atomic.Int32
+	var resumedFrom atomic.Value
+	dyn.PrependWatchReactor("configmaps", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		if watches.Add(1) > 1 {
+			return false, nil, nil
+		}
+		resumedFrom.Store(action.(clienttesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+		expired := watch.NewFakeWithChanSize(1, false)
+		expired.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+		return true, expired, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w := NewWatcher(disc, dyn, []string{"configmaps"}, Options{Namespace: "shop", Checkpoints: store, CheckpointInterval: time.Hour})
+	events := w.Run(ctx)
+
+	var got []string
+	for range 2 {
+		select {
+		case event := <-events:
+			got = append(got, string(event.Type)+" "+event.Object.GetKind()+" "+event.Object.GetName())
+			event.Ack()
+		case <-ctx.Done():
+			t.Fatalf("Run() timed out after events %v", got)
+		}
+	}
+	slices.Sort(got)
+	if want := []string{"ADDED ConfigMap flags", "DELETED ConfigMap gone"}; !slices.Equal(got, want) {
+		t.Errorf("Run() resumed events = %v, want %v", got, want)
+	}
+	if rv, _ := resumedFrom.Load().(string); rv != "3" {
+		t.Errorf("Run() resumed from %q, want the checkpointed 3", rv)
+	}
+
+	// The first change after the restart is diffed against the listed object
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return watches.Load() > 1, nil
+	})
+	if err != nil {
+		t.Fatalf("Run() did not watch again after relisting")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := dyn.Resource(configMapsGVR).Namespace("shop").Update(ctx, watchObject(configMapsGVR, "ConfigMap", "settings", "5"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != watch.Modified || event.OldObject == nil || event.OldObject.GetResourceVersion() != "1" {
+			t.Errorf("Run() event after update = %s with old %v, want MODIFIED with the listed object", event.Type, event.OldObject)
+		}
+		// Not acknowledged, the checkpoint does not cover it
+	case <-ctx.Done():
+		t.Fatalf("Run() timed out waiting for the update")
+	}
+
+	cancel()
+	for range events {
+	}
+	checkpoints, err := store.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"shop/settings": "1", "shop/flags": "4"}
+	if cp := checkpoints["configmaps"]; cp == nil || !reflect.DeepEqual(cp.Objects, want) {
+		t.Errorf("saved checkpoint = %+v, want objects %v", cp, want)
+	}
+}
+
+func TestAckQueue(t *testing.T) {
+	q := &ackQueue{done: map[uint64]func(){}}
+	var advanced []uint64
+	for _, seq := range []uint64{1, 2, 0, 4} {
+		q.ack(seq, func() { advanced = append(advanced, seq) })
+	}
+	// 4 waits for 3
+	if want := []uint64{0, 1, 2}; !slices.Equal(advanced, want) {
+		t.Errorf("ack() advanced = %v, want %v", advanced, want)
+	}
+}
+
+func TestWatcherAdvance(t *testing.T) {
+	w := NewWatcher(nil, nil, nil, Options{})
+	// A relisted object and a late acknowledgement come after newer events
+	w.advance(configMapsGVR, watch.Modified, watchObject(configMapsGVR, "ConfigMap", "settings", "7"))
+	w.advance(configMapsGVR, watch.Added, watchObject(configMapsGVR, "ConfigMap", "flags", "4"))
+	w.advance(configMapsGVR, watch.Deleted, watchObject(configMapsGVR, "ConfigMap", "gone", "2"))
+
+	cp := w.checkpoints["configmaps"]
+	want := map[string]string{"shop/settings": "7", "shop/flags": "4"}
+	if cp.ResourceVersion != "7" || !reflect.DeepEqual(cp.Objects, want) {
+		t.Errorf("advance() checkpoint = %+v, want resourceVersion 7 and objects %v", cp, want)
+	}
+}
+
+func TestFitCheckpoints(t *testing.T) {
+	objects := func(n int) map[string]string {
+		m := map[string]string{}
+		for i := range n {
+			m[fmt.Sprintf("shop/object-%d", i)] = "1"
+		}
+		return m
+	}
+	checkpoints := Checkpoints{
+		"pods":       {ResourceVersion: "9", Objects: objects(100)},
+		"configmaps": {ResourceVersion: "8", Objects: objects(2)},
+	}
+	data, err := fitCheckpoints(checkpoints, 1000)
+	if err != nil {
+		t.Fatalf("fitCheckpoints() error = %v", err)
+	}
+	got := Checkpoints{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 1000 || got["pods"].Objects != nil || got["pods"].ResourceVersion != "9" || len(got["configmaps"].Objects) != 2 {
+		t.Errorf("fitCheckpoints() = %s, want the pods objects left out", data)
+	}
+	if len(checkpoints["pods"].Objects) != 100 {
+		t.Errorf("fitCheckpoints() modified its argument")
+	}
+	if _, err := fitCheckpoints(checkpoints, 10); err == nil {
+		t.Errorf("fitCheckpoints() error = nil, want too large")
+	}
+}
@@ -0,0 +1,129 @@
+package kubewatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// FileConfig configures a rotating JSON lines file
+type FileConfig struct {
+	Path string `json:"path"`
+	// MaxSize rotates the file before it grows past it, 100MiB by default.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MaxBackups is how many rotated files are kept as path.1, path.2 and so on, 5 by default.
+	MaxBackups int `json:"maxBackups,omitempty"`
+}
+
+// FileSink appends records as JSON lines to a file it rotates by size
+type FileSink struct {
+	cfg  FileConfig
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens or creates the file of cfg
+func NewFileSink(cfg FileConfig) (*FileSink, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("file path is empty")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 100 << 20
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = 5
+	}
+	s := &FileSink{cfg: cfg}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and starts a new file.
+// When shifting fails the current file is reopened, the file is nil only when
+// that fails too.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shift()
+	}
+	if openErr := s.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (s *FileSink) shift() error {
+	for i := s.cfg.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.cfg.Path, i), fmt.Sprintf("%s.%d", s.cfg.Path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(s.cfg.Path, s.cfg.Path+".1")
+}
+
+// Send appends the records, rotating first when a line would overflow the file.
+// A failed rotation is retried on the next line, the records are appended past
+// the max size meanwhile.
+func (s *FileSink) Send(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if s.size > 0 && s.size+int64(len(line)) > s.cfg.MaxSize {
+			if err := s.rotate(); err != nil {
+				if s.file == nil {
+					return fmt.Errorf("rotate %s: %w", s.cfg.Path, err)
+				}
+				slog.Error("rotate file failed", "path", s.cfg.Path, "size", s.size, "error", err)
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,110 @@
+package kubewatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func recordNames(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("%s: invalid line %q: %v", path, scanner.Text(), err)
+		}
+		names = append(names, record.Name)
+	}
+	return names
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	line, _ := json.Marshal(sinkRecord("ADDED", "a"))
+	// Two lines fit in a file, two backups are kept
+	cfg := FileConfig{Path: path, MaxSize: int64(2*len(line) + 2), MaxBackups: 2}
+
+	sink, err := NewFileSink(cfg)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	if err := sink.Send(context.Background(), []Record{sinkRecord("ADDED", "a"), sinkRecord("ADDED", "b"), sinkRecord("ADDED", "c")}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Reopening appends to the current file
+	sink, err = NewFileSink(cfg)
+	if err != nil {
+		t.Fatalf("NewFileSink() reopen error = %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Send(context.Background(), []Record{sinkRecord("ADDED", "d"), sinkRecord("ADDED", "e"), sinkRecord("ADDED", "f"), sinkRecord("ADDED", "g")}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	want := map[string][]string{
+		path:        {"g"},
+		path + ".1": {"e", "f"},
+		path + ".2": {"c", "d"},
+	}
+	for p, names := range want {
+		if got := recordNames(t, p); !slices.Equal(got, names) {
+			t.Errorf("%s = %v, want %v", filepath.Base(p), got, names)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 backups", path)
+	}
+}
+
+func TestFileSinkRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	line, _ := json.Marshal(sinkRecord("ADDED", "a"))
+	sink, err := NewFileSink(FileConfig{Path: path, MaxSize: int64(len(line) + 1), MaxBackups: 1})
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	// A directory in the way of the backup makes the rotations fail, the
+	// records are appended to the current file meanwhile
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := sink.Send(context.Background(), []Record{sinkRecord("ADDED", name)}); err != nil {
+			t.Fatalf("Send(%s) with a failing rotation error = %v", name, err)
+		}
+	}
+	if got := recordNames(t, path); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("%s = %v, want [a b c]", filepath.Base(path), got)
+	}
+
+	// The next rotation succeeds once the obstacle is gone
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), []Record{sinkRecord("ADDED", "d")}); err != nil {
+		t.Fatalf("Send() after the obstacle is gone error = %v", err)
+	}
+	if got := recordNames(t, path); !slices.Equal(got, []string{"d"}) {
+		t.Errorf("%s = %v, want [d]", filepath.Base(path), got)
+	}
+	if got := recordNames(t, path+".1"); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("%s.1 = %v, want [a b c]", filepath.Base(path), got)
+	}
+}
@@ -0,0 +1,124 @@
+package kubewatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+// Record is the printed form of an event
+type Record struct {
+	Time       time.Time         `json:"time"`
+	Type       string            `json:"type"`
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Name       string            `json:"name"`
+	Message    string            `json:"message,omitempty"`
+	Diff       []jsondiff.Change `json:"diff,omitempty"`
+}
+
+// Printer writes events as human text, JSON lines or YAML documents
+type Printer struct {
+	w       io.Writer
+	format  string
+	ignored []string
+	now     func() time.Time
+}
+
+// NewPrinter creates a printer of the format text, json or yaml. Modified events
+// carry the diff to the previous object, leaving out the ignored paths.
+func NewPrinter(w io.Writer, format string, ignored []string) (*Printer, error) {
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return nil, fmt.Errorf("unknown output format %q, want text, json or yaml", format)
+	}
+	return &Printer{w: w, format: format, ignored: ignored, now: time.Now}, nil
+}
+
+// NewRecord builds the record of an event, diffing Modified objects
+func (p *Printer) NewRecord(event Event) Record {
+	return newRecord(event, p.ignored, p.now())
+}
+
+func newRecord(event Event, ignored []string, now time.Time) Record {
+	record := Record{
+		Time:       now.UTC(),
+		Type:       string(event.Type),
+		APIVersion: event.Object.GetAPIVersion(),
+		Kind:       event.Object.GetKind(),
+		Namespace:  event.Object.GetNamespace(),
+		Name:       event.Object.GetName(),
+		Message:    event.Message,
+	}
+	if event.Type == watch.Modified && event.OldObject != nil {
+		record.Diff = jsondiff.Diff(event.OldObject.Object, event.Object.Object, ignored)
+	}
+	return record
+}
+
+// Print writes an event
+func (p *Printer) Print(event Event) error {
+	return p.PrintRecord(p.NewRecord(event))
+}
+
+// PrintRecord writes a record
+func (p *Printer) PrintRecord(record Record) error {
+	switch p.format {
+	case "json":
+		return json.NewEncoder(p.w).Encode(record)
+	case "yaml":
+		data, err := yaml.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "---\n%s", data)
+		return err
+	}
+	return p.printText(record)
+}
+
+func (p *Printer) printText(record Record) error {
+	name := record.Name
+	if record.Namespace != "" {
+		name = record.Namespace + "/" + name
+	}
+	if _, err := fmt.Fprintf(p.w, "%s %s %s %s\n", record.Time.Format(time.RFC3339), record.Type, record.Kind, name); err != nil {
+		return err
+	}
+	if record.Message != "" {
+		if _, err := fmt.Fprintf(p.w, "  %s\n", record.Message); err != nil {
+			return err
+		}
+	}
+	for _, change := range record.Diff {
+		var err error
+		switch change.Op {
+		case "add":
+			_, err = fmt.Fprintf(p.w, "  + %s: %s\n", change.Path, compact(change.Value))
+		case "remove":
+			_, err = fmt.Fprintf(p.w, "  - %s: %s\n", change.Path, compact(change.Old))
+		default:
+			_, err = fmt.Fprintf(p.w, "  ~ %s: %s -> %s\n", change.Path, compact(change.Old), compact(change.Value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
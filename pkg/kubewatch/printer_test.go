@@ -0,0 +1,75 @@
+package kubewatch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+func TestPrinter(t *testing.T) {
+	old := watchObject(deploymentsGVR, "Deployment", "web", "1")
+	old.Object["spec"] = map[string]any{"replicas": int64(1)}
+	current := watchObject(deploymentsGVR, "Deployment", "web", "2")
+	current.Object["spec"] = map[string]any{"replicas": int64(3)}
+	current.Object["status"] = map[string]any{"readyReplicas": int64(3)}
+	events := []Event{
+		{Type: watch.Added, Resource: deploymentsGVR, Object: old},
+		{Type: watch.Modified, Resource: deploymentsGVR, Object: current, OldObject: old},
+		{Type: "RestartStorm", Resource: deploymentsGVR, Object: current, Message: "6 restarts in 10m0s"},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want: "2026-10-19T08:30:00Z ADDED Deployment shop/web\n" +
+				"2026-10-19T08:30:00Z MODIFIED Deployment shop/web\n" +
+				"  ~ /spec/replicas: 1 -> 3\n" +
+				"2026-10-19T08:30:00Z RestartStorm Deployment shop/web\n" +
+				"  6 restarts in 10m0s\n",
+		},
+		{
+			format: "json",
+			want: `{"time":"2026-10-19T08:30:00Z","type":"ADDED","apiVersion":"apps/v1","kind":"Deployment","namespace":"shop","name":"web"}` + "\n" +
+				`{"time":"2026-10-19T08:30:00Z","type":"MODIFIED","apiVersion":"apps/v1","kind":"Deployment","namespace":"shop","name":"web",` +
+				`"diff":[{"op":"replace","path":"/spec/replicas","value":3,"old":1}]}` + "\n" +
+				`{"time":"2026-10-19T08:30:00Z","type":"RestartStorm","apiVersion":"apps/v1","kind":"Deployment","namespace":"shop","name":"web",` +
+				`"message":"6 restarts in 10m0s"}` + "\n",
+		},
+		{
+			format: "yaml",
+			want: "---\napiVersion: apps/v1\nkind: Deployment\nname: web\nnamespace: shop\ntime: \"2026-10-19T08:30:00Z\"\ntype: ADDED\n" +
+				"---\napiVersion: apps/v1\ndiff:\n- old: 1\n  op: replace\n  path: /spec/replicas\n  value: 3\n" +
+				"kind: Deployment\nname: web\nnamespace: shop\ntime: \"2026-10-19T08:30:00Z\"\ntype: MODIFIED\n" +
+				"---\napiVersion: apps/v1\nkind: Deployment\nmessage: 6 restarts in 10m0s\nname: web\nnamespace: shop\ntime: \"2026-10-19T08:30:00Z\"\ntype: RestartStorm\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := NewPrinter(&buf, tt.format, jsondiff.DefaultIgnored)
+			if err != nil {
+				t.Fatalf("NewPrinter() error = %v", err)
+			}
+			p.now = func() time.Time { return time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC) }
+			for _, event := range events {
+				if err := p.Print(event); err != nil {
+					t.Fatalf("Print() error = %v", err)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Print() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewPrinter(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Errorf("NewPrinter() unknown format error = nil")
+	}
+}
@@ -0,0 +1,74 @@
+package kubewatch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+// ruleEnv declares the variables of a rule: the event type, the object and the
+// previous object of a Modified event, null otherwise
+var ruleEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("type", cel.StringType),
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.OptionalTypes(),
+	)
+})
+
+// Rule is a compiled CEL expression selecting events, like
+//
+//	type == "DELETED" && object.kind == "Namespace" && object.metadata.?labels.?team == optional.of("payments")
+//	type == "MODIFIED" && object.status.phase == "Failed" && oldObject.status.phase != "Failed"
+type Rule struct {
+	expr    string
+	program cel.Program
+}
+
+// CompileRule parses and checks a rule, which has to evaluate to a bool
+func CompileRule(expr string) (*Rule, error) {
+	env, err := ruleEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid rule %q: %w", expr, issues.Err())
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("invalid rule %q: evaluates to %s, want bool", expr, t)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %q: %w", expr, err)
+	}
+	return &Rule{expr: expr, program: program}, nil
+}
+
+// String returns the expression
+func (r *Rule) String() string {
+	return r.expr
+}
+
+// Match evaluates the rule against an event. Accessing a field the object does
+// not have is an error, has() or optional fields like object.?spec guard it.
+func (r *Rule) Match(event Event) (bool, error) {
+	vars := map[string]any{"type": string(event.Type), "object": nil, "oldObject": nil}
+	if event.Object != nil {
+		vars["object"] = event.Object.Object
+	}
+	if event.OldObject != nil {
+		vars["oldObject"] = event.OldObject.Object
+	}
+	out, _, err := r.program.Eval(vars)
+	if err != nil {
+		return false, fmt.Errorf("rule %q: %w", r.expr, err)
+	}
+	match, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("rule %q: evaluated to %v, want bool", r.expr, out.Value())
+	}
+	return match, nil
+}
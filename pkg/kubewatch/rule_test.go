@@ -0,0 +1,78 @@
+package kubewatch
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestCompileRule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: `type == "DELETED"`},
+		{expr: `object.spec.paused`},
+		{expr: `type == `, wantErr: "Syntax error"},
+		{expr: `kind == "Pod"`, wantErr: "undeclared reference to 'kind'"},
+		{expr: `type + "x"`, wantErr: "evaluates to string, want bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := CompileRule(tt.expr)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("CompileRule() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CompileRule() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	payments := watchObject(configMapsGVR, "Namespace", "payments", "1")
+	payments.SetNamespace("")
+	payments.SetLabels(map[string]string{"team": "payments"})
+	search := watchObject(configMapsGVR, "Namespace", "search", "1")
+	search.SetNamespace("")
+	running := watchObject(configMapsGVR, "Pod", "web", "1")
+	running.Object["status"] = map[string]any{"phase": "Running"}
+	failed := watchObject(configMapsGVR, "Pod", "web", "2")
+	failed.Object["status"] = map[string]any{"phase": "Failed"}
+
+	deletedPayments := `type == "DELETED" && object.kind == "Namespace" && object.metadata.?labels.?team == optional.of("payments")`
+	podFailed := `type == "MODIFIED" && object.status.phase == "Failed" && oldObject.status.phase != "Failed"`
+	tests := []struct {
+		name    string
+		expr    string
+		event   Event
+		want    bool
+		wantErr bool
+	}{
+		{name: "deleted payments namespace", expr: deletedPayments, event: Event{Type: watch.Deleted, Object: payments}, want: true},
+		{name: "added payments namespace", expr: deletedPayments, event: Event{Type: watch.Added, Object: payments}},
+		{name: "deleted unlabeled namespace", expr: deletedPayments, event: Event{Type: watch.Deleted, Object: search}},
+		{name: "pod failed", expr: podFailed, event: Event{Type: watch.Modified, Object: failed, OldObject: running}, want: true},
+		{name: "pod still failed", expr: podFailed, event: Event{Type: watch.Modified, Object: failed, OldObject: failed}},
+		{name: "pod running", expr: podFailed, event: Event{Type: watch.Modified, Object: running, OldObject: failed}},
+		{name: "missing field", expr: `object.status.phase == "Failed"`, event: Event{Type: watch.Added, Object: search}, wantErr: true},
+		{name: "no old object", expr: `oldObject == null`, event: Event{Type: watch.Added, Object: search}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := CompileRule(tt.expr)
+			if err != nil {
+				t.Fatalf("CompileRule() error = %v", err)
+			}
+			got, err := rule.Match(tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
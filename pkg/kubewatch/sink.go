@@ -0,0 +1,415 @@
+package kubewatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+// Sink receives batches of event records
+type Sink interface {
+	Send(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// Config lists the sinks events are forwarded to
+type Config struct {
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// SinkConfig configures one sink, which is one of webhook, slack, file or stdout
+type SinkConfig struct {
+	Name   string       `json:"name"`
+	Type   string       `json:"type"`
+	Filter FilterConfig `json:"filter,omitempty"`
+	Batch  BatchConfig  `json:"batch,omitempty"`
+	Queue  QueueConfig  `json:"queue,omitempty"`
+	// Ignore are the paths left out of Modified diffs, jsondiff.DefaultIgnored when unset.
+	Ignore *[]string `json:"ignore,omitempty"`
+
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	File    *FileConfig    `json:"file,omitempty"`
+	// Format is the stdout format: text, json or yaml, json by default.
+	Format string `json:"format,omitempty"`
+}
+
+// FilterConfig selects events, an empty field matches everything
+type FilterConfig struct {
+	Types         []string `json:"types,omitempty"`
+	Kinds         []string `json:"kinds,omitempty"`
+	Namespaces    []string `json:"namespaces,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	// Rule is a CEL expression, see Rule.
+	Rule string `json:"rule,omitempty"`
+}
+
+// BatchConfig groups records before sending, by default each record is sent alone
+type BatchConfig struct {
+	Size     int             `json:"size,omitempty"`
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// Overflow policies of a full sink queue
+const (
+	OverflowDrop  = "drop"
+	OverflowBlock = "block"
+)
+
+// QueueConfig bounds the records waiting for a sink, so a slow sink does not
+// hold back the others
+type QueueConfig struct {
+	// Size is how many records wait for the sink, 1000 by default.
+	Size int `json:"size,omitempty"`
+	// Overflow is drop to drop the records not fitting, the default, or block to
+	// wait for room, which holds back every sink.
+	Overflow string `json:"overflow,omitempty"`
+	// Lossy acknowledges the records dropped or failing to send, so the
+	// checkpoints move past them and a resumed watch does not replay them. By
+	// default they stay unacknowledged: the checkpoints of their resources stop
+	// there until a restart, and the later events are held in memory meanwhile.
+	Lossy bool `json:"lossy,omitempty"`
+}
+
+// LoadConfig reads a YAML or JSON sinks config
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Filter matches events against a FilterConfig
+type Filter struct {
+	types      []string
+	kinds      []string
+	namespaces []string
+	selector   labels.Selector
+	rule       *Rule
+}
+
+// NewFilter compiles a filter config
+func NewFilter(cfg FilterConfig) (*Filter, error) {
+	selector, err := labels.Parse(cfg.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("label selector %q: %w", cfg.LabelSelector, err)
+	}
+	f := &Filter{types: cfg.Types, kinds: cfg.Kinds, namespaces: cfg.Namespaces, selector: selector}
+	if cfg.Rule != "" {
+		if f.rule, err = CompileRule(cfg.Rule); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the event passes the filter. A rule failing to evaluate
+// does not match.
+func (f *Filter) Match(event Event) bool {
+	if !matchAny(f.types, string(event.Type)) ||
+		!matchAny(f.kinds, event.Object.GetKind()) ||
+		!matchAny(f.namespaces, event.Object.GetNamespace()) ||
+		!f.selector.Matches(labels.Set(event.Object.GetLabels())) {
+		return false
+	}
+	if f.rule == nil {
+		return true
+	}
+	match, err := f.rule.Match(event)
+	if err != nil {
+		slog.Debug("evaluate rule failed", "kind", event.Object.GetKind(), "name", event.Object.GetName(), "error", err)
+		return false
+	}
+	return match
+}
+
+func matchAny(values []string, value string) bool {
+	return len(values) == 0 || slices.Contains(values, value)
+}
+
+// Dispatcher forwards events to the matching sinks, batching per sink
+type Dispatcher struct {
+	routes []*route
+	now    func() time.Time
+}
+
+type route struct {
+	name     string
+	sink     Sink
+	filter   *Filter
+	batch    BatchConfig
+	ignored  []string
+	overflow string
+	lossy    bool
+	records  chan queuedRecord
+	// dropped counts the records lost to a full queue
+	dropped int
+}
+
+// queuedRecord waits for its sink, ack is called once it was sent
+type queuedRecord struct {
+	record Record
+	ack    func()
+}
+
+// NewDispatcher builds the sinks of cfg, failing on the first invalid one
+func NewDispatcher(cfg *Config) (*Dispatcher, error) {
+	d := &Dispatcher{now: time.Now}
+	names := map[string]bool{}
+	for i, sc := range cfg.Sinks {
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("%s-%d", sc.Type, i)
+		}
+		if names[sc.Name] {
+			d.Close()
+			return nil, fmt.Errorf("sink %s: duplicate name", sc.Name)
+		}
+		names[sc.Name] = true
+		r, err := newRoute(sc)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		d.routes = append(d.routes, r)
+	}
+	return d, nil
+}
+
+func newRoute(sc SinkConfig) (*route, error) {
+	filter, err := NewFilter(sc.Filter)
+	if err != nil {
+		return nil, err
+	}
+	switch sc.Queue.Overflow {
+	case "":
+		sc.Queue.Overflow = OverflowDrop
+	case OverflowDrop, OverflowBlock:
+	default:
+		return nil, fmt.Errorf("unknown queue overflow %q, want drop or block", sc.Queue.Overflow)
+	}
+	sink, err := newSink(sc)
+	if err != nil {
+		return nil, err
+	}
+	ignored := jsondiff.DefaultIgnored
+	if sc.Ignore != nil {
+		ignored = *sc.Ignore
+	}
+	if sc.Batch.Size <= 0 {
+		sc.Batch.Size = 1
+	}
+	if sc.Batch.Interval.Duration <= 0 {
+		sc.Batch.Interval.Duration = time.Second
+	}
+	if sc.Queue.Size <= 0 {
+		sc.Queue.Size = 1000
+	}
+	return &route{
+		name:     sc.Name,
+		sink:     sink,
+		filter:   filter,
+		batch:    sc.Batch,
+		ignored:  ignored,
+		overflow: sc.Queue.Overflow,
+		lossy:    sc.Queue.Lossy,
+		records:  make(chan queuedRecord, sc.Queue.Size),
+	}, nil
+}
+
+func newSink(sc SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case "webhook":
+		if sc.Webhook == nil {
+			return nil, errors.New("webhook sink needs a webhook section")
+		}
+		return NewWebhookSink(*sc.Webhook, encodeRecords)
+	case "slack":
+		if sc.Webhook == nil {
+			return nil, errors.New("slack sink needs a webhook section")
+		}
+		return NewWebhookSink(*sc.Webhook, encodeSlack)
+	case "file":
+		if sc.File == nil {
+			return nil, errors.New("file sink needs a file section")
+		}
+		return NewFileSink(*sc.File)
+	case "stdout":
+		format := sc.Format
+		if format == "" {
+			format = "json"
+		}
+		return NewWriterSink(os.Stdout, format)
+	}
+	return nil, fmt.Errorf("unknown sink type %q, want webhook, slack, file or stdout", sc.Type)
+}
+
+// Run forwards events until the channel is closed, then flushes the pending
+// batches and closes the sinks. Each sink has its own queue, a full one drops
+// or blocks according to its overflow policy. An event is acknowledged once
+// every matching sink was sent its record, or dropped it when lossy.
+func (d *Dispatcher) Run(ctx context.Context, events <-chan Event) {
+	var wg sync.WaitGroup
+	for _, r := range d.routes {
+		wg.Go(func() { r.run(ctx) })
+	}
+	for event := range events {
+		now := d.now()
+		var matched []*route
+		for _, r := range d.routes {
+			if r.filter.Match(event) {
+				matched = append(matched, r)
+			}
+		}
+		if len(matched) == 0 {
+			event.Ack()
+			continue
+		}
+		ack := ackAfter(len(matched), event)
+		for _, r := range matched {
+			r.enqueue(ctx, queuedRecord{record: newRecord(event, r.ignored, now), ack: ack})
+		}
+	}
+	for _, r := range d.routes {
+		close(r.records)
+	}
+	wg.Wait()
+	d.Close()
+}
+
+// Close closes the sinks
+func (d *Dispatcher) Close() {
+	for _, r := range d.routes {
+		if err := r.sink.Close(); err != nil {
+			slog.Error("close sink failed", "sink", r.name, "error", err)
+		}
+	}
+}
+
+// ackAfter acknowledges event once called n times
+func ackAfter(n int, event Event) func() {
+	var left atomic.Int32
+	left.Store(int32(n))
+	return func() {
+		if left.Add(-1) == 0 {
+			event.Ack()
+		}
+	}
+}
+
+// enqueue queues a record for the sink, dropping it when the queue is full
+// unless the overflow policy is block. A dropped record is only acknowledged
+// when the route is lossy.
+func (r *route) enqueue(ctx context.Context, q queuedRecord) {
+	select {
+	case r.records <- q:
+		return
+	default:
+	}
+	if r.overflow != OverflowBlock {
+		r.dropped++
+		slog.Warn("sink queue full, record dropped", "sink", r.name, "kind", q.record.Kind, "name", q.record.Name, "dropped", r.dropped)
+		if r.lossy {
+			q.ack()
+		}
+		return
+	}
+	select {
+	case r.records <- q:
+	case <-ctx.Done():
+		// Not acknowledged, a resumed watch repeats the event
+		slog.Warn("sink queue full on shutdown, record dropped", "sink", r.name, "kind", q.record.Kind, "name", q.record.Name)
+	}
+}
+
+// run sends the records once a batch is full or the interval passed. The last
+// batch is sent with a fresh context since ctx is usually done by then.
+func (r *route) run(ctx context.Context) {
+	ticker := time.NewTicker(r.batch.Interval.Duration)
+	defer ticker.Stop()
+	batch := make([]Record, 0, r.batch.Size)
+	acks := make([]func(), 0, r.batch.Size)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		// A batch failing after the retries of the sink holds back the
+		// checkpoints unless the route is lossy
+		err := r.sink.Send(ctx, batch)
+		if err != nil {
+			slog.Error("send to sink failed", "sink", r.name, "records", len(batch), "lossy", r.lossy, "error", err)
+		}
+		if err == nil || r.lossy {
+			for _, ack := range acks {
+				ack()
+			}
+		}
+		batch = make([]Record, 0, r.batch.Size)
+		acks = make([]func(), 0, r.batch.Size)
+	}
+	for {
+		select {
+		case q, ok := <-r.records:
+			if !ok {
+				flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+				flush(flushCtx)
+				cancel()
+				return
+			}
+			batch, acks = append(batch, q.record), append(acks, q.ack)
+			if len(batch) >= r.batch.Size {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// WriterSink prints records to a writer like stdout
+type WriterSink struct {
+	mu      sync.Mutex
+	printer *Printer
+}
+
+// NewWriterSink creates a sink printing in the format text, json or yaml
+func NewWriterSink(w io.Writer, format string) (*WriterSink, error) {
+	printer, err := NewPrinter(w, format, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterSink{printer: printer}, nil
+}
+
+// Send prints the records
+func (s *WriterSink) Send(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range records {
+		if err := s.printer.PrintRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing, the writer is not owned
+func (s *WriterSink) Close() error {
+	return nil
+}
@@ -0,0 +1,322 @@
+package kubewatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+// recordingSink keeps the batches it received
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]string
+	closed  bool
+}
+
+func (s *recordingSink) Send(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var batch []string
+	for _, record := range records {
+		batch = append(batch, record.Type+" "+record.Name)
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func labeledEvent(typ watch.EventType, name string, labels map[string]string) Event {
+	obj := watchObject(deploymentsGVR, "Deployment", name, "1")
+	obj.SetLabels(labels)
+	return Event{Type: typ, Resource: deploymentsGVR, Object: obj}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sinks.yaml")
+	config := `sinks:
+- name: audit
+  type: file
+  file:
+    path: ` + filepath.Join(t.TempDir(), "audit.jsonl") + `
+  batch:
+    size: 10
+    interval: 5s
+- name: payments
+  type: slack
+  filter:
+    types: [DELETED]
+    labelSelector: team=payments
+  webhook:
+    url: http://chat.example.com/hook
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.Sinks) != 2 || cfg.Sinks[0].Batch.Interval.Duration != 5*time.Second || cfg.Sinks[1].Filter.LabelSelector != "team=payments" {
+		t.Errorf("LoadConfig() = %+v", cfg)
+	}
+	d, err := NewDispatcher(cfg)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	d.Close()
+
+	if err := os.WriteFile(path, []byte("sinks:\n- type: stdout\n  colour: red\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("LoadConfig() unknown field error = nil")
+	}
+}
+
+func TestNewDispatcherErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinks   []SinkConfig
+		wantErr string
+	}{
+		{name: "unknown type", sinks: []SinkConfig{{Name: "x", Type: "pager"}}, wantErr: "sink x: unknown sink type"},
+		{name: "missing webhook", sinks: []SinkConfig{{Type: "webhook"}}, wantErr: "sink webhook-0: webhook sink needs a webhook section"},
+		{name: "bad rule", sinks: []SinkConfig{{Type: "stdout", Filter: FilterConfig{Rule: "type =="}}}, wantErr: "sink stdout-0: invalid rule"},
+		{name: "bad selector", sinks: []SinkConfig{{Type: "stdout", Filter: FilterConfig{LabelSelector: "team in ("}}}, wantErr: "label selector"},
+		{name: "bad overflow", sinks: []SinkConfig{{Type: "stdout", Queue: QueueConfig{Overflow: "spill"}}}, wantErr: "unknown queue overflow"},
+		{name: "duplicate", sinks: []SinkConfig{{Name: "a", Type: "stdout"}, {Name: "a", Type: "stdout"}}, wantErr: "sink a: duplicate name"},
+		{name: "missing secret", sinks: []SinkConfig{{Type: "webhook", Webhook: &WebhookConfig{URL: "http://x", SecretEnv: "KUBEWATCH_UNSET_SECRET"}}}, wantErr: "KUBEWATCH_UNSET_SECRET is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDispatcher(&Config{Sinks: tt.sinks}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewDispatcher() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	all, deleted := &recordingSink{}, &recordingSink{}
+	d := &Dispatcher{now: time.Now}
+	for _, sc := range []struct {
+		sink   Sink
+		filter FilterConfig
+		size   int
+	}{
+		{sink: all, size: 2},
+		{sink: deleted, filter: FilterConfig{Types: []string{"DELETED"}, LabelSelector: "team=payments"}, size: 1},
+	} {
+		filter, err := NewFilter(sc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.routes = append(d.routes, &route{
+			sink:     sc.sink,
+			filter:   filter,
+			batch:    BatchConfig{Size: sc.size, Interval: metav1.Duration{Duration: time.Hour}},
+			ignored:  jsondiff.DefaultIgnored,
+			overflow: OverflowBlock,
+			records:  make(chan queuedRecord, sc.size),
+		})
+	}
+
+	events := make(chan Event)
+	var acked atomic.Int32
+	go func() {
+		defer close(events)
+		for _, event := range []Event{
+			labeledEvent(watch.Added, "web", map[string]string{"team": "payments"}),
+			labeledEvent(watch.Deleted, "api", map[string]string{"team": "search"}),
+			labeledEvent(watch.Deleted, "web", map[string]string{"team": "payments"}),
+		} {
+			event.ack = func() { acked.Add(1) }
+			events <- event
+		}
+	}()
+	d.Run(context.Background(), events)
+
+	if got, want := all.batches, [][]string{{"ADDED web", "DELETED api"}, {"DELETED web"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfiltered sink batches = %v, want %v", got, want)
+	}
+	if got, want := deleted.batches, [][]string{{"DELETED web"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filtered sink batches = %v, want %v", got, want)
+	}
+	if !all.closed || !deleted.closed {
+		t.Errorf("Run() left sinks open")
+	}
+	// DELETED web went to both sinks and is acknowledged once
+	if n := acked.Load(); n != 3 {
+		t.Errorf("Run() acknowledged %d events, want 3", n)
+	}
+}
+
+// blockingSink holds every Send until released
+type blockingSink struct {
+	recordingSink
+	release chan struct{}
+}
+
+func (s *blockingSink) Send(ctx context.Context, records []Record) error {
+	<-s.release
+	return s.recordingSink.Send(ctx, records)
+}
+
+func TestDispatcherOverflow(t *testing.T) {
+	tests := []struct {
+		overflow string
+		// cancel stops a blocked dispatch
+		cancel bool
+	}{
+		{overflow: OverflowDrop},
+		{overflow: OverflowBlock, cancel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overflow, func(t *testing.T) {
+			slow, fast := &blockingSink{release: make(chan struct{})}, &recordingSink{}
+			d := &Dispatcher{now: time.Now}
+			// The fast sink has room for every record
+			for sink, size := range map[Sink]int{slow: 1, fast: 4} {
+				filter, _ := NewFilter(FilterConfig{})
+				d.routes = append(d.routes, &route{
+					sink:     sink,
+					filter:   filter,
+					batch:    BatchConfig{Size: 1, Interval: metav1.Duration{Duration: time.Hour}},
+					overflow: tt.overflow,
+					records:  make(chan queuedRecord, size),
+				})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events := make(chan Event)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				d.Run(ctx, events)
+			}()
+			sent := make(chan struct{})
+			var acked atomic.Int32
+			go func() {
+				defer close(sent)
+				for _, name := range []string{"a", "b", "c", "d"} {
+					event := labeledEvent(watch.Added, name, nil)
+					event.ack = func() { acked.Add(1) }
+					events <- event
+				}
+				close(events)
+			}()
+			if tt.cancel {
+				time.Sleep(50 * time.Millisecond)
+				cancel()
+			}
+			// The slow sink does not hold back the dispatch
+			select {
+			case <-sent:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Run() blocked on the slow sink")
+			}
+			close(slow.release)
+			<-done
+
+			if len(slow.batches) == 4 {
+				t.Errorf("slow sink batches = %v, want records dropped", slow.batches)
+			}
+			if !tt.cancel && len(fast.batches) != 4 {
+				t.Errorf("fast sink batches = %v, want all 4 records", fast.batches)
+			}
+			// The records dropped by the slow sink are not acknowledged
+			if n := int(acked.Load()); n != len(slow.batches) {
+				t.Errorf("Run() acknowledged %d events, want the %d the slow sink got", n, len(slow.batches))
+			}
+		})
+	}
+}
+
+// failingSink fails every Send
+type failingSink struct{}
+
+func (failingSink) Send(context.Context, []Record) error { return errors.New("webhook down") }
+
+func (failingSink) Close() error { return nil }
+
+func TestDispatcherSendFailure(t *testing.T) {
+	for _, lossy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lossy=%t", lossy), func(t *testing.T) {
+			filter, _ := NewFilter(FilterConfig{})
+			d := &Dispatcher{now: time.Now, routes: []*route{{
+				sink:    failingSink{},
+				filter:  filter,
+				batch:   BatchConfig{Size: 1, Interval: metav1.Duration{Duration: time.Hour}},
+				lossy:   lossy,
+				records: make(chan queuedRecord, 2),
+			}}}
+
+			events := make(chan Event, 2)
+			var acked atomic.Int32
+			for _, name := range []string{"a", "b"} {
+				event := labeledEvent(watch.Added, name, nil)
+				event.ack = func() { acked.Add(1) }
+				events <- event
+			}
+			close(events)
+			d.Run(context.Background(), events)
+
+			want := int32(0)
+			if lossy {
+				want = 2
+			}
+			if n := acked.Load(); n != want {
+				t.Errorf("Run() acknowledged %d failed events, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	event := labeledEvent(watch.Modified, "web", map[string]string{"team": "payments"})
+	tests := []struct {
+		name   string
+		filter FilterConfig
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "type", filter: FilterConfig{Types: []string{"ADDED", "MODIFIED"}}, want: true},
+		{name: "other type", filter: FilterConfig{Types: []string{"DELETED"}}},
+		{name: "kind and namespace", filter: FilterConfig{Kinds: []string{"Deployment"}, Namespaces: []string{"shop"}}, want: true},
+		{name: "other namespace", filter: FilterConfig{Namespaces: []string{"kube-system"}}},
+		{name: "labels", filter: FilterConfig{LabelSelector: "team in (payments, search)"}, want: true},
+		{name: "other labels", filter: FilterConfig{LabelSelector: "team!=payments"}},
+		{name: "rule", filter: FilterConfig{Kinds: []string{"Deployment"}, Rule: `object.metadata.name.startsWith("we")`}, want: true},
+		{name: "other rule", filter: FilterConfig{Rule: `type == "DELETED"`}},
+		{name: "failing rule", filter: FilterConfig{Rule: `object.spec.replicas > 1`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+			if got := f.Match(event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
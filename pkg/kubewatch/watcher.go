@@ -0,0 +1,406 @@
+package kubewatch
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/urans/kubemaze/pkg/tour"
+)
+
+// Event is a watch event of any resource
+type Event struct {
+	Type     watch.EventType
+	Resource schema.GroupVersionResource
+	Object   *unstructured.Unstructured
+	// OldObject is the previous state of a Modified object.
+	OldObject *unstructured.Unstructured
+	// Message describes events not coming from a watch, like alerts.
+	Message string
+
+	ack func()
+}
+
+// Ack acknowledges the event was handled, the checkpoint of its resource only
+// moves past acknowledged events. Acknowledging twice or an event not coming
+// from a Watcher does nothing.
+func (e Event) Ack() {
+	if e.ack != nil {
+		e.ack()
+	}
+}
+
+// Options configures a Watcher
+type Options struct {
+	// Namespace limits namespaced resources, empty means all namespaces.
+	Namespace string
+	// Rediscover is how often missing resources are looked up again, 30s by default.
+	Rediscover time.Duration
+	// SkipInitial drops the Added events of objects existing when a watch starts.
+	SkipInitial bool
+	// Checkpoints persists the progress of each resource to resume from after a
+	// restart. Delivery is at least once, a resumed watch may repeat events. The
+	// progress only covers the events acknowledged with Event.Ack.
+	Checkpoints CheckpointStore
+	// CheckpointInterval is how often the progress is saved, 5s by default.
+	CheckpointInterval time.Duration
+}
+
+// Watcher watches resources named at runtime, like pods, deployments.apps or
+// memcacheds.cache.urans.com, through the dynamic client
+type Watcher struct {
+	disc      discovery.DiscoveryInterface
+	dyn       dynamic.Interface
+	resources []string
+	opts      Options
+	events    chan Event
+
+	mu      sync.Mutex
+	running map[schema.GroupVersionResource]context.CancelFunc
+	missing map[string]bool
+	wg      sync.WaitGroup
+
+	checkpoints Checkpoints
+	dirty       bool
+}
+
+// NewWatcher creates a watcher of resources
+func NewWatcher(disc discovery.DiscoveryInterface, dyn dynamic.Interface, resources []string, opts Options) *Watcher {
+	if opts.Rediscover <= 0 {
+		opts.Rediscover = 30 * time.Second
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = 5 * time.Second
+	}
+	return &Watcher{
+		disc:      disc,
+		dyn:       dyn,
+		resources: resources,
+		opts:      opts,
+		events:    make(chan Event),
+		running:   map[schema.GroupVersionResource]context.CancelFunc{},
+		missing:   map[string]bool{},
+
+		checkpoints: Checkpoints{},
+	}
+}
+
+// Run watches all resolvable resources concurrently until ctx is done. It
+// rediscovers periodically, starting watches for kinds which appear, like after
+// a CRD install, and stopping those which disappear. The returned channel is
+// closed once every watch stopped and the last checkpoint was saved.
+func (w *Watcher) Run(ctx context.Context) <-chan Event {
+	if w.opts.Checkpoints != nil {
+		checkpoints, err := w.opts.Checkpoints.Load(ctx)
+		if err != nil {
+			slog.Error("load checkpoints failed, starting over", "error", err)
+		} else {
+			w.checkpoints = checkpoints
+		}
+	}
+	go func() {
+		defer close(w.events)
+		rediscover := time.NewTicker(w.opts.Rediscover)
+		defer rediscover.Stop()
+		saver := time.NewTicker(w.opts.CheckpointInterval)
+		defer saver.Stop()
+
+		w.reconcile(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				w.wg.Wait()
+				w.saveCheckpoints(context.WithoutCancel(ctx))
+				return
+			case <-saver.C:
+				w.saveCheckpoints(ctx)
+			case <-rediscover.C:
+				w.reconcile(ctx)
+			}
+		}
+	}()
+	return w.events
+}
+
+// Watching returns the resources currently watched
+func (w *Watcher) Watching() []schema.GroupVersionResource {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	gvrs := make([]schema.GroupVersionResource, 0, len(w.running))
+	for gvr := range w.running {
+		gvrs = append(gvrs, gvr)
+	}
+	return gvrs
+}
+
+func (w *Watcher) reconcile(ctx context.Context) {
+	groups, err := restmapper.GetAPIGroupResources(w.disc)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		slog.Error("discover resources failed", "error", err)
+		return
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groups)
+
+	wanted := map[schema.GroupVersionResource]*meta.RESTMapping{}
+	for _, arg := range w.resources {
+		mapping, err := resolve(mapper, arg)
+		w.mu.Lock()
+		wasMissing := w.missing[arg]
+		w.missing[arg] = err != nil
+		w.mu.Unlock()
+		if err != nil {
+			if !wasMissing {
+				slog.Warn("resource not found, waiting for it to appear", "resource", arg, "error", err)
+			}
+			continue
+		}
+		wanted[mapping.Resource] = mapping
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for gvr, cancel := range w.running {
+		if wanted[gvr] == nil {
+			slog.Warn("resource disappeared, watch stopped", "resource", gvr.String())
+			cancel()
+			delete(w.running, gvr)
+		}
+	}
+	for gvr, mapping := range wanted {
+		if _, ok := w.running[gvr]; ok {
+			continue
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		w.running[gvr] = cancel
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.watch(watchCtx, mapping)
+		}()
+	}
+}
+
+// resolve maps a resource argument like pods, deployments.apps or
+// deployments.v1.apps to the mapping of the preferred served version
+func resolve(mapper meta.RESTMapper, arg string) (*meta.RESTMapping, error) {
+	fullySpecified, groupResource := schema.ParseResourceArg(arg)
+	var gvr schema.GroupVersionResource
+	err := errors.New("not fully specified")
+	if fullySpecified != nil {
+		gvr, err = mapper.ResourceFor(*fullySpecified)
+	}
+	if err != nil {
+		gvr, err = mapper.ResourceFor(groupResource.WithVersion(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return nil, err
+	}
+	return mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+func (w *Watcher) watch(ctx context.Context, mapping *meta.RESTMapping) {
+	gvr := mapping.Resource
+	var client dynamic.ResourceInterface = w.dyn.Resource(gvr)
+	// Cluster-scoped resources are watched whatever the namespace
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		client = w.dyn.Resource(gvr).Namespace(w.opts.Namespace)
+	}
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.List(ctx, opts)
+		},
+		WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(ctx, opts)
+		},
+	}
+	var events <-chan tour.WatchEvent[*unstructured.Unstructured]
+	var err error
+	known := map[string]*unstructured.Unstructured{}
+	if cp := w.checkpoint(gvr); cp != nil {
+		slog.Info("resuming watch", "resource", gvr.String(), "resourceVersion", cp.ResourceVersion, "objects", len(cp.Objects))
+		// The current objects are the previous state of the first changes after
+		// the replayed ones
+		if list, err := client.List(ctx, metav1.ListOptions{}); err != nil {
+			slog.Warn("list resource failed, first changes come without a diff", "resource", gvr.String(), "error", err)
+		} else {
+			for i := range list.Items {
+				if key, err := cache.MetaNamespaceKeyFunc(&list.Items[i]); err == nil {
+					known[key] = &list.Items[i]
+				}
+			}
+		}
+		events, err = tour.Resume(ctx, lw, tour.WatchOptions{}, cp.ResourceVersion, cp.stubs())
+	} else {
+		events, err = tour.Watch[*unstructured.Unstructured](ctx, lw, tour.WatchOptions{SkipInitial: w.opts.SkipInitial})
+	}
+	if err != nil {
+		slog.Error("watch resource failed", "resource", gvr.String(), "error", err)
+		// Forget the resource so the next rediscovery retries it, unless the
+		// rediscovery already stopped this watch and cancelled its context
+		w.mu.Lock()
+		if cancel, ok := w.running[gvr]; ok && ctx.Err() == nil {
+			cancel()
+			delete(w.running, gvr)
+		}
+		w.mu.Unlock()
+		return
+	}
+	slog.Info("watching resource", "resource", gvr.String(), "namespace", w.opts.Namespace)
+	acks := &ackQueue{done: map[uint64]func(){}}
+	var seq uint64
+	for event := range events {
+		out := Event{Type: event.Type, Resource: gvr, Object: event.Object}
+		if key, err := cache.MetaNamespaceKeyFunc(event.Object); err == nil {
+			// A replayed change is not newer than the object listed on resume
+			if old := known[key]; event.Type == watch.Modified && old != nil && newerVersion(event.Object, old) {
+				out.OldObject = old
+			}
+			if event.Type == watch.Deleted {
+				delete(known, key)
+			} else {
+				known[key] = event.Object
+			}
+		}
+		var once sync.Once
+		n := seq
+		seq++
+		out.ack = func() {
+			once.Do(func() {
+				acks.ack(n, func() { w.advance(gvr, event.Type, event.Object) })
+			})
+		}
+		select {
+		case w.events <- out:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newerVersion reports whether obj has a later resourceVersion than old
+func newerVersion(obj, old *unstructured.Unstructured) bool {
+	return newerResourceVersion(obj.GetResourceVersion(), old.GetResourceVersion())
+}
+
+// newerResourceVersion reports whether rv is later than old, true when they do
+// not compare as numbers since only their order matters here
+func newerResourceVersion(rv, old string) bool {
+	n, err := strconv.ParseUint(rv, 10, 64)
+	if err != nil {
+		return true
+	}
+	oldN, err := strconv.ParseUint(old, 10, 64)
+	if err != nil {
+		return true
+	}
+	return n > oldN
+}
+
+// ackQueue runs the acknowledgements of the events of a watch in their order,
+// holding those coming early until the previous events are acknowledged too
+type ackQueue struct {
+	mu   sync.Mutex
+	next uint64
+	done map[uint64]func()
+}
+
+func (q *ackQueue) ack(seq uint64, advance func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.done[seq] = advance
+	for {
+		advance, ok := q.done[q.next]
+		if !ok {
+			return
+		}
+		delete(q.done, q.next)
+		advance()
+		q.next++
+	}
+}
+
+// checkpoint returns the resumable checkpoint of gvr, if any
+func (w *Watcher) checkpoint(gvr schema.GroupVersionResource) *Checkpoint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cp := w.checkpoints[checkpointKey(gvr)]
+	if cp == nil || cp.ResourceVersion == "" || cp.ResourceVersion == "0" {
+		return nil
+	}
+	return cp
+}
+
+// advance records an acknowledged event in the checkpoint of gvr
+func (w *Watcher) advance(gvr schema.GroupVersionResource, typ watch.EventType, obj *unstructured.Unstructured) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cp := w.checkpoints[checkpointKey(gvr)]
+	if cp == nil {
+		cp = &Checkpoint{Objects: map[string]string{}}
+		w.checkpoints[checkpointKey(gvr)] = cp
+	}
+	if cp.Objects == nil {
+		cp.Objects = map[string]string{}
+	}
+	// Relisted objects and deletion stubs carry older resourceVersions, the
+	// checkpoint never moves back
+	if rv := obj.GetResourceVersion(); rv != "" && newerResourceVersion(rv, cp.ResourceVersion) {
+		cp.ResourceVersion = rv
+	}
+	cp.APIVersion, cp.Kind = obj.GetAPIVersion(), obj.GetKind()
+	if typ == watch.Deleted {
+		delete(cp.Objects, key)
+	} else {
+		cp.Objects[key] = obj.GetResourceVersion()
+	}
+	w.dirty = true
+}
+
+func (w *Watcher) saveCheckpoints(ctx context.Context) {
+	if w.opts.Checkpoints == nil {
+		return
+	}
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return
+	}
+	checkpoints := make(Checkpoints, len(w.checkpoints))
+	for key, cp := range w.checkpoints {
+		copied := *cp
+		copied.Objects = maps.Clone(cp.Objects)
+		checkpoints[key] = &copied
+	}
+	w.dirty = false
+	w.mu.Unlock()
+
+	if err := w.opts.Checkpoints.Save(ctx, checkpoints); err != nil {
+		slog.Error("save checkpoints failed", "error", err)
+		w.mu.Lock()
+		w.dirty = true
+		w.mu.Unlock()
+	}
+}
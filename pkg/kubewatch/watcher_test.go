@@ -0,0 +1,170 @@
+package kubewatch
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsGVR  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	memcachedsGVR  = schema.GroupVersionResource{Group: "cache.urans.com", Version: "v1", Resource: "memcacheds"}
+)
+
+// lockedDiscovery lets the test change the served resources while the watcher discovers
+type lockedDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	mu sync.Mutex
+}
+
+func (d *lockedDiscovery) ServerGroupsAndResources() ([]*metav1.APIGroup, []*metav1.APIResourceList, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.FakeDiscovery.ServerGroupsAndResources()
+}
+
+func (d *lockedDiscovery) setResources(resources ...*metav1.APIResourceList) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Resources = resources
+}
+
+func resourceList(gvr schema.GroupVersionResource, kind string) *metav1.APIResourceList {
+	return &metav1.APIResourceList{
+		GroupVersion: gvr.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: gvr.Resource, Kind: kind, Namespaced: true, Verbs: []string{"list", "watch"}}},
+	}
+}
+
+// watchObject carries a resourceVersion, which the fake dynamic client does not set
+func watchObject(gvr schema.GroupVersionResource, kind, name, rv string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	obj.SetAPIVersion(gvr.GroupVersion().String())
+	obj.SetKind(kind)
+	obj.SetNamespace("shop")
+	obj.SetName(name)
+	obj.SetResourceVersion(rv)
+	return obj
+}
+
+func TestWatcher(t *testing.T) {
+	disc := &lockedDiscovery{FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}}
+	disc.setResources(resourceList(configMapsGVR, "ConfigMap"), resourceList(deploymentsGVR, "Deployment"))
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			configMapsGVR:  "ConfigMapList",
+			deploymentsGVR: "DeploymentList",
+			memcachedsGVR:  "MemcachedList",
+		},
+		watchObject(configMapsGVR, "ConfigMap", "settings", "1"),
+		watchObject(deploymentsGVR, "Deployment", "web", "2"),
+		watchObject(memcachedsGVR, "Memcached", "cache", "3"),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w := NewWatcher(disc, dyn, []string{"configmaps", "deployments.apps", "memcacheds.cache.urans.com"},
+		Options{Namespace: "shop", Rediscover: 10 * time.Millisecond})
+	events := w.Run(ctx)
+
+	next := func(n int) []string {
+		t.Helper()
+		var got []string
+		for range n {
+			select {
+			case event := <-events:
+				got = append(got, string(event.Type)+" "+event.Object.GetKind()+" "+event.Object.GetName()+" "+event.Resource.Resource)
+			case <-ctx.Done():
+				t.Fatalf("Run() timed out after events %v", got)
+			}
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	want := []string{"ADDED ConfigMap settings configmaps", "ADDED Deployment web deployments"}
+	if got := next(2); !slices.Equal(got, want) {
+		t.Errorf("Run() initial events = %v, want %v", got, want)
+	}
+
+	// The Memcached CRD gets installed
+	disc.setResources(resourceList(configMapsGVR, "ConfigMap"), resourceList(deploymentsGVR, "Deployment"), resourceList(memcachedsGVR, "Memcached"))
+	want = []string{"ADDED Memcached cache memcacheds"}
+	if got := next(1); !slices.Equal(got, want) {
+		t.Errorf("Run() events after CRD install = %v, want %v", got, want)
+	}
+
+	if _, err := dyn.Resource(configMapsGVR).Namespace("shop").Create(ctx, watchObject(configMapsGVR, "ConfigMap", "flags", "4"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"ADDED ConfigMap flags configmaps"}
+	if got := next(1); !slices.Equal(got, want) {
+		t.Errorf("Run() events after create = %v, want %v", got, want)
+	}
+
+	if _, err := dyn.Resource(configMapsGVR).Namespace("shop").Update(ctx, watchObject(configMapsGVR, "ConfigMap", "settings", "5"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != watch.Modified || event.OldObject == nil || event.OldObject.GetResourceVersion() != "1" {
+			t.Errorf("Run() event after update = %s with old %v, want MODIFIED with the previous object", event.Type, event.OldObject)
+		}
+	case <-ctx.Done():
+		t.Fatalf("Run() timed out waiting for the update")
+	}
+
+	// The Memcached CRD gets removed
+	disc.setResources(resourceList(configMapsGVR, "ConfigMap"), resourceList(deploymentsGVR, "Deployment"))
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return len(w.Watching()) == 2 && !slices.Contains(w.Watching(), memcachedsGVR), nil
+	})
+	if err != nil {
+		t.Errorf("Watching() = %v after CRD removal, want configmaps and deployments", w.Watching())
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatcherClusterScoped(t *testing.T) {
+	nodesGVR := schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	nodes := resourceList(nodesGVR, "Node")
+	nodes.APIResources[0].Namespaced = false
+	disc := &lockedDiscovery{FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}}
+	disc.setResources(nodes)
+	node := watchObject(nodesGVR, "Node", "node-1", "1")
+	node.SetNamespace("")
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{nodesGVR: "NodeList"}, node)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// The namespace only limits namespaced resources
+	events := NewWatcher(disc, dyn, []string{"nodes"}, Options{Namespace: "shop"}).Run(ctx)
+	select {
+	case event := <-events:
+		if event.Type != watch.Added || event.Object.GetName() != "node-1" {
+			t.Errorf("Run() event = %s %s, want ADDED node-1", event.Type, event.Object.GetName())
+		}
+	case <-ctx.Done():
+		t.Fatalf("Run() timed out waiting for the node")
+	}
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,172 @@
+package kubewatch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, prefixed with sha256=
+const SignatureHeader = "X-Kubewatch-Signature"
+
+// WebhookConfig configures an HTTP sink
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Secret signs the body, SecretEnv names an environment variable holding it.
+	Secret    string `json:"secret,omitempty"`
+	SecretEnv string `json:"secretEnv,omitempty"`
+	// Retries is how often a failed request is retried, 3 by default.
+	Retries *int            `json:"retries,omitempty"`
+	Backoff metav1.Duration `json:"backoff,omitempty"`
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// WebhookSink posts batches of records, retrying server errors with an
+// exponential backoff
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	secret  []byte
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	encode  func([]Record) ([]byte, error)
+}
+
+// NewWebhookSink creates a sink posting the records encoded by encode
+func NewWebhookSink(cfg WebhookConfig, encode func([]Record) ([]byte, error)) (*WebhookSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+	s := &WebhookSink{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		secret:  []byte(cfg.Secret),
+		retries: 3,
+		backoff: cfg.Backoff.Duration,
+		client:  &http.Client{Timeout: cfg.Timeout.Duration},
+		encode:  encode,
+	}
+	if cfg.SecretEnv != "" {
+		secret, ok := os.LookupEnv(cfg.SecretEnv)
+		if !ok {
+			return nil, fmt.Errorf("secret environment variable %s is not set", cfg.SecretEnv)
+		}
+		s.secret = []byte(secret)
+	}
+	if cfg.Retries != nil {
+		s.retries = *cfg.Retries
+	}
+	if s.backoff <= 0 {
+		s.backoff = time.Second
+	}
+	if s.client.Timeout <= 0 {
+		s.client.Timeout = 10 * time.Second
+	}
+	return s, nil
+}
+
+// Sign returns the signature header value of body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts the records, giving up after the retries or on a client error
+func (s *WebhookSink) Send(ctx context.Context, records []Record) error {
+	body, err := s.encode(records)
+	if err != nil {
+		return err
+	}
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *WebhookSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	if len(s.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(s.secret, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close closes the idle connections
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// encodeRecords encodes a batch as a JSON array
+func encodeRecords(records []Record) ([]byte, error) {
+	return json.Marshal(records)
+}
+
+// encodeSlack encodes a batch as an incoming webhook message, one line per record
+func encodeSlack(records []Record) ([]byte, error) {
+	var text strings.Builder
+	for i, record := range records {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+		name := record.Name
+		if record.Namespace != "" {
+			name = record.Namespace + "/" + name
+		}
+		fmt.Fprintf(&text, "*%s* %s `%s`", record.Type, record.Kind, name)
+		if record.Message != "" {
+			fmt.Fprintf(&text, "\n> %s", record.Message)
+		}
+		for _, change := range record.Diff {
+			fmt.Fprintf(&text, "\n> %s `%s`", change.Op, change.Path)
+			if change.Op != "remove" {
+				fmt.Fprintf(&text, " = `%s`", compact(change.Value))
+			}
+		}
+	}
+	return json.Marshal(map[string]string{"text": text.String()})
+}
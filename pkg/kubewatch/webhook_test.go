@@ -0,0 +1,129 @@
+package kubewatch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/urans/kubemaze/pkg/jsondiff"
+)
+
+// webhookServer answers with the queued statuses, then 200, recording the bodies
+type webhookServer struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, body)
+	s.headers = append(s.headers, req.Header.Clone())
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func sinkRecord(typ, name string) Record {
+	return Record{
+		Time:       time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC),
+		Type:       typ,
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  "shop",
+		Name:       name,
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	retries := 2
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		wantReqs int
+	}{
+		{name: "ok", wantReqs: 1},
+		{name: "retried server errors", statuses: []int{500, 503}, wantReqs: 3},
+		{name: "retries exhausted", statuses: []int{500, 502, 503}, wantErr: true, wantReqs: 3},
+		{name: "client error not retried", statuses: []int{400}, wantErr: true, wantReqs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &webhookServer{statuses: tt.statuses}
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			t.Setenv("KUBEWATCH_SECRET", "s3cret")
+			sink, err := NewWebhookSink(WebhookConfig{
+				URL:       srv.URL,
+				Headers:   map[string]string{"X-Team": "payments"},
+				SecretEnv: "KUBEWATCH_SECRET",
+				Retries:   &retries,
+				Backoff:   metav1.Duration{Duration: time.Millisecond},
+			}, encodeRecords)
+			if err != nil {
+				t.Fatalf("NewWebhookSink() error = %v", err)
+			}
+			defer sink.Close()
+
+			records := []Record{sinkRecord("ADDED", "web"), sinkRecord("DELETED", "api")}
+			if err := sink.Send(context.Background(), records); (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(server.bodies) != tt.wantReqs {
+				t.Fatalf("Send() made %d requests, want %d", len(server.bodies), tt.wantReqs)
+			}
+			body, header := server.bodies[0], server.headers[0]
+			if got, want := header.Get(SignatureHeader), Sign([]byte("s3cret"), body); got != want {
+				t.Errorf("signature = %s, want %s", got, want)
+			}
+			if header.Get("X-Team") != "payments" {
+				t.Errorf("headers = %v, want X-Team", header)
+			}
+			var got []Record
+			if err := json.Unmarshal(body, &got); err != nil || len(got) != 2 || got[1].Name != "api" {
+				t.Errorf("body = %s, error = %v, want both records", body, err)
+			}
+		})
+	}
+}
+
+func TestEncodeSlack(t *testing.T) {
+	modified := sinkRecord("MODIFIED", "web")
+	modified.Diff = []jsondiff.Change{
+		{Op: "replace", Path: "/spec/replicas", Value: int64(3), Old: int64(1)},
+		{Op: "remove", Path: "/metadata/labels/tier", Old: "front"},
+	}
+	storm := sinkRecord("RestartStorm", "db")
+	storm.Message = "6 restarts in 10m0s"
+	data, err := encodeSlack([]Record{sinkRecord("ADDED", "api"), modified, storm})
+	if err != nil {
+		t.Fatalf("encodeSlack() error = %v", err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := "*ADDED* Deployment `shop/api`\n" +
+		"*MODIFIED* Deployment `shop/web`\n" +
+		"> replace `/spec/replicas` = `3`\n" +
+		"> remove `/metadata/labels/tier`\n" +
+		"*RestartStorm* Deployment `shop/db`\n" +
+		"> 6 restarts in 10m0s"
+	if got["text"] != want {
+		t.Errorf("encodeSlack() text =\n%s\nwant\n%s", got["text"], want)
+	}
+}
@@ -0,0 +1,85 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// LatencySummary holds the startup latency percentiles of a workload stage, in seconds
+type LatencySummary struct {
+	Namespace string  `json:"namespace"`
+	Workload  string  `json:"workload"`
+	Stage     Stage   `json:"stage"`
+	Count     int     `json:"count"`
+	P50       float64 `json:"p50"`
+	P90       float64 `json:"p90"`
+	P99       float64 `json:"p99"`
+}
+
+// Latencies summarizes the recent startup latencies, optionally of one
+// namespace and workload
+func (t *Tracker) Latencies(namespace, workload string) []LatencySummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	summaries := []LatencySummary{}
+	for key, samples := range t.samples {
+		if (namespace != "" && key.namespace != namespace) || (workload != "" && key.workload != workload) {
+			continue
+		}
+		sorted := slices.Sorted(slices.Values(samples))
+		summaries = append(summaries, LatencySummary{
+			Namespace: key.namespace,
+			Workload:  key.workload,
+			Stage:     key.stage,
+			Count:     len(sorted),
+			P50:       percentile(sorted, 0.5),
+			P90:       percentile(sorted, 0.9),
+			P99:       percentile(sorted, 0.99),
+		})
+	}
+	slices.SortFunc(summaries, func(a, b LatencySummary) int {
+		if c := strings.Compare(a.Namespace+"/"+a.Workload, b.Namespace+"/"+b.Workload); c != 0 {
+			return c
+		}
+		return slices.Index(startupStages, a.Stage) - slices.Index(startupStages, b.Stage)
+	})
+	return summaries
+}
+
+// percentile picks the nearest rank of sorted samples
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// Handler serves /timelines and /latency as JSON, filtered by the namespace and
+// workload query parameters, and the metrics of gatherer on /metrics
+func (t *Tracker) Handler(gatherer prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /timelines", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, t.Timelines(req.URL.Query().Get("namespace")))
+	})
+	mux.HandleFunc("GET /latency", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		writeJSON(w, t.Latencies(query.Get("namespace"), query.Get("workload")))
+	})
+	mux.Handle("GET /metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("write response failed", "error", err)
+	}
+}
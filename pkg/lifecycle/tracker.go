@@ -0,0 +1,250 @@
+package lifecycle
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urans/kubemaze/pkg/tour"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Stage is a step of the pod lifecycle
+type Stage string
+
+const (
+	StageScheduled         Stage = "scheduled"
+	StageInitialized       Stage = "initialized"
+	StageContainersStarted Stage = "containers_started"
+	StageReady             Stage = "ready"
+	StageTerminated        Stage = "terminated"
+)
+
+// startupStages are the stages whose latency since creation is measured
+var startupStages = []Stage{StageScheduled, StageInitialized, StageContainersStarted, StageReady}
+
+// DefaultMaxSamples bounds the latencies kept per workload and stage for percentiles
+const DefaultMaxSamples = 1000
+
+// Timeline is when a pod reached each stage of its lifecycle
+type Timeline struct {
+	Namespace         string    `json:"namespace"`
+	Name              string    `json:"name"`
+	Workload          string    `json:"workload"`
+	Created           time.Time `json:"created"`
+	Scheduled         time.Time `json:"scheduled,omitzero"`
+	Initialized       time.Time `json:"initialized,omitzero"`
+	ContainersStarted time.Time `json:"containersStarted,omitzero"`
+	Ready             time.Time `json:"ready,omitzero"`
+	Terminated        time.Time `json:"terminated,omitzero"`
+}
+
+func (t *Timeline) stage(stage Stage) *time.Time {
+	switch stage {
+	case StageScheduled:
+		return &t.Scheduled
+	case StageInitialized:
+		return &t.Initialized
+	case StageContainersStarted:
+		return &t.ContainersStarted
+	case StageReady:
+		return &t.Ready
+	}
+	return &t.Terminated
+}
+
+type samplesKey struct {
+	namespace string
+	workload  string
+	stage     Stage
+}
+
+// Tracker records pod timelines from informer notifications and measures the
+// startup latencies per namespace and workload
+type Tracker struct {
+	mu         sync.Mutex
+	pods       map[types.UID]*Timeline
+	samples    map[samplesKey][]float64
+	histogram  *prometheus.HistogramVec
+	maxSamples int
+	retention  time.Duration
+	now        func() time.Time
+}
+
+// NewTracker creates a tracker registering its histogram to reg. Timelines of
+// terminated pods are kept for retention.
+func NewTracker(reg prometheus.Registerer, retention time.Duration) (*Tracker, error) {
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pod_startup_latency_seconds",
+		Help:    "Time from pod creation until it reached a lifecycle stage.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 12),
+	}, []string{"namespace", "workload", "stage"})
+	if err := reg.Register(histogram); err != nil {
+		return nil, err
+	}
+	return &Tracker{
+		pods:       map[types.UID]*Timeline{},
+		samples:    map[samplesKey][]float64{},
+		histogram:  histogram,
+		maxSamples: DefaultMaxSamples,
+		retention:  retention,
+		now:        time.Now,
+	}, nil
+}
+
+// EventHandler feeds the tracker from a pod informer
+func (t *Tracker) EventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc:    func(obj any, isInInitialList bool) { t.observe(obj, false, isInInitialList) },
+		UpdateFunc: func(_, obj any) { t.observe(obj, false, false) },
+		DeleteFunc: func(obj any) { t.observe(obj, true, false) },
+	}
+}
+
+// observe updates the timeline of a pod. The stages a pod of the initial list
+// already reached are recorded in its timeline but not measured, they happened
+// before the tracker started and would skew the latencies.
+func (t *Tracker) observe(obj any, deleted, initial bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timeline := t.pods[pod.UID]
+	if timeline == nil {
+		timeline = &Timeline{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Workload:  Workload(pod),
+			Created:   pod.CreationTimestamp.Time,
+		}
+		t.pods[pod.UID] = timeline
+	}
+
+	reached := map[Stage]time.Time{
+		StageScheduled:         conditionTime(pod, corev1.PodScheduled),
+		StageInitialized:       conditionTime(pod, corev1.PodInitialized),
+		StageContainersStarted: containersStarted(pod),
+		StageReady:             conditionTime(pod, corev1.PodReady),
+		StageTerminated:        terminated(pod),
+	}
+	if deleted && reached[StageTerminated].IsZero() {
+		reached[StageTerminated] = t.now()
+	}
+	for _, stage := range append(slices.Clone(startupStages), StageTerminated) {
+		at := timeline.stage(stage)
+		// The first time counts, a pod turning ready again is no startup
+		if !at.IsZero() || reached[stage].IsZero() {
+			continue
+		}
+		*at = reached[stage]
+		if stage != StageTerminated && !timeline.Created.IsZero() && !initial {
+			t.record(timeline, stage, at.Sub(timeline.Created).Seconds())
+		}
+	}
+	if deleted {
+		t.sweep()
+	}
+}
+
+func (t *Tracker) record(timeline *Timeline, stage Stage, seconds float64) {
+	seconds = max(seconds, 0)
+	t.histogram.WithLabelValues(timeline.Namespace, timeline.Workload, string(stage)).Observe(seconds)
+	key := samplesKey{namespace: timeline.Namespace, workload: timeline.Workload, stage: stage}
+	samples := append(t.samples[key], seconds)
+	if len(samples) > t.maxSamples {
+		samples = samples[len(samples)-t.maxSamples:]
+	}
+	t.samples[key] = samples
+}
+
+// sweep forgets the pods terminated longer than the retention ago
+func (t *Tracker) sweep() {
+	cutoff := t.now().Add(-t.retention)
+	for uid, timeline := range t.pods {
+		if !timeline.Terminated.IsZero() && timeline.Terminated.Before(cutoff) {
+			delete(t.pods, uid)
+		}
+	}
+}
+
+// Timelines returns the tracked pods of a namespace, all namespaces when empty
+func (t *Tracker) Timelines(namespace string) []Timeline {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timelines := []Timeline{}
+	for _, timeline := range t.pods {
+		if namespace == "" || timeline.Namespace == namespace {
+			timelines = append(timelines, *timeline)
+		}
+	}
+	slices.SortFunc(timelines, func(a, b Timeline) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+	return timelines
+}
+
+// Workload names the workload owning a pod, none for a bare pod so that the
+// histogram series stay per workload
+func Workload(pod *corev1.Pod) string {
+	ref := tour.PodWorkload(pod, nil)
+	if ref.Kind == "Pod" {
+		return "none"
+	}
+	return ref.Kind + "/" + ref.Name
+}
+
+func conditionTime(pod *corev1.Pod, typ corev1.PodConditionType) time.Time {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == typ && cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// containersStarted is when the last container of the pod started, zero until all did
+func containersStarted(pod *corev1.Pod) time.Time {
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) || len(pod.Status.ContainerStatuses) == 0 {
+		return time.Time{}
+	}
+	var last time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		var started time.Time
+		switch {
+		case status.State.Running != nil:
+			started = status.State.Running.StartedAt.Time
+		case status.State.Terminated != nil:
+			started = status.State.Terminated.StartedAt.Time
+		}
+		if started.IsZero() {
+			return time.Time{}
+		}
+		if started.After(last) {
+			last = started
+		}
+	}
+	return last
+}
+
+// terminated is when the last container of a finished pod exited
+func terminated(pod *corev1.Pod) time.Time {
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return time.Time{}
+	}
+	var last time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(last) {
+			last = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	return last
+}
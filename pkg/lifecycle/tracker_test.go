@@ -0,0 +1,182 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+var created = time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+
+// lifecyclePod is a web pod of the shop Deployment, having reached the stages
+// at the given seconds after creation, zero for not yet
+func lifecyclePod(name string, scheduled, initialized, started, ready int) *corev1.Pod {
+	at := func(s int) metav1.Time { return metav1.NewTime(created.Add(time.Duration(s) * time.Second)) }
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "shop",
+			Name:              name,
+			UID:               types.UID("uid-" + name),
+			CreationTimestamp: at(0),
+			Labels:            map[string]string{"pod-template-hash": "5d8f7"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7", Controller: new(true),
+			}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+	}
+	for typ, s := range map[corev1.PodConditionType]int{corev1.PodScheduled: scheduled, corev1.PodInitialized: initialized, corev1.PodReady: ready} {
+		if s > 0 {
+			pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: typ, Status: corev1.ConditionTrue, LastTransitionTime: at(s)})
+		}
+	}
+	if started > 0 {
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "web", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: at(started)}}}}
+	}
+	return pod
+}
+
+func TestWorkload(t *testing.T) {
+	tests := []struct {
+		name  string
+		owner *metav1.OwnerReference
+		hash  string
+		want  string
+	}{
+		{name: "deployment", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-5d8f7", Controller: new(true)}, hash: "5d8f7", want: "Deployment/web"},
+		{name: "bare replicaset", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web", Controller: new(true)}, want: "ReplicaSet/web"},
+		{name: "statefulset", owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db", Controller: new(true)}, want: "StatefulSet/db"},
+		{name: "not controller", owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db"}, want: "none"},
+		{name: "none", want: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"pod-template-hash": tt.hash}}}
+			if tt.owner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*tt.owner}
+			}
+			if got := Workload(pod); got != tt.want {
+				t.Errorf("Workload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerInitialList(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	tracker, err := NewTracker(reg, time.Hour)
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	handler := tracker.EventHandler()
+
+	// web-a was ready before the tracker started, web-b was still starting
+	handler.OnAdd(lifecyclePod("web-a", 1, 2, 3, 4), true)
+	handler.OnAdd(lifecyclePod("web-b", 1, 0, 0, 0), true)
+	handler.OnUpdate(nil, lifecyclePod("web-a", 1, 2, 3, 4))
+	handler.OnUpdate(nil, lifecyclePod("web-b", 1, 2, 3, 5))
+
+	if timelines := tracker.Timelines("shop"); len(timelines) != 2 || !timelines[0].Ready.Equal(created.Add(4*time.Second)) {
+		t.Errorf("Timelines() = %+v, want both pods with web-a ready", timelines)
+	}
+	got := map[Stage]int{}
+	for _, s := range tracker.Latencies("shop", "Deployment/web") {
+		got[s.Stage] = s.Count
+	}
+	want := map[Stage]int{StageInitialized: 1, StageContainersStarted: 1, StageReady: 1}
+	for _, stage := range startupStages {
+		if got[stage] != want[stage] {
+			t.Errorf("Latencies() %s count = %d, want %d", stage, got[stage], want[stage])
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	tracker, err := NewTracker(reg, time.Hour)
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	tracker.now = func() time.Time { return created.Add(time.Minute) }
+	handler := tracker.EventHandler()
+
+	// web-a goes through its stages, web-b starts slower, web-c is still pending
+	handler.OnAdd(lifecyclePod("web-a", 0, 0, 0, 0), false)
+	handler.OnUpdate(nil, lifecyclePod("web-a", 1, 2, 0, 0))
+	handler.OnUpdate(nil, lifecyclePod("web-a", 1, 2, 3, 4))
+	handler.OnAdd(lifecyclePod("web-b", 2, 4, 8, 10), false)
+	handler.OnAdd(lifecyclePod("web-c", 1, 0, 0, 0), false)
+	// A pod turning ready again does not count twice
+	handler.OnUpdate(nil, lifecyclePod("web-a", 1, 2, 3, 30))
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "shop/web-b", Obj: lifecyclePod("web-b", 2, 4, 8, 10)})
+
+	timelines := tracker.Timelines("shop")
+	if len(timelines) != 3 {
+		t.Fatalf("Timelines() = %d, want 3", len(timelines))
+	}
+	a, b := timelines[0], timelines[1]
+	if a.Name != "web-a" || a.Workload != "Deployment/web" || !a.Ready.Equal(created.Add(4*time.Second)) || !a.Terminated.IsZero() {
+		t.Errorf("Timelines() web-a = %+v", a)
+	}
+	if !b.Terminated.Equal(created.Add(time.Minute)) {
+		t.Errorf("Timelines() web-b terminated = %v, want the deletion time", b.Terminated)
+	}
+
+	got := map[Stage]LatencySummary{}
+	for _, s := range tracker.Latencies("shop", "Deployment/web") {
+		got[s.Stage] = s
+	}
+	want := map[Stage]LatencySummary{
+		StageScheduled:         {Count: 3, P50: 1, P90: 2, P99: 2},
+		StageInitialized:       {Count: 2, P50: 2, P90: 4, P99: 4},
+		StageContainersStarted: {Count: 2, P50: 3, P90: 8, P99: 8},
+		StageReady:             {Count: 2, P50: 4, P90: 10, P99: 10},
+	}
+	for stage, w := range want {
+		g := got[stage]
+		if g.Count != w.Count || g.P50 != w.P50 || g.P90 != w.P90 || g.P99 != w.P99 {
+			t.Errorf("Latencies() %s = %+v, want %+v", stage, g, w)
+		}
+	}
+	if n := testutil.CollectAndCount(reg, "pod_startup_latency_seconds"); n != 4 {
+		t.Errorf("histogram series = %d, want one per stage", n)
+	}
+
+	srv := httptest.NewServer(tracker.Handler(reg))
+	defer srv.Close()
+	for path, check := range map[string]string{
+		"/latency?namespace=shop&workload=Deployment/web": `"stage":"ready"`,
+		"/timelines?namespace=blog":                       `[]`,
+		"/metrics":                                        `pod_startup_latency_seconds_bucket{namespace="shop",stage="ready",workload="Deployment/web",le="4"} 1`,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), check) {
+			t.Errorf("GET %s = %d %s, want %s", path, resp.StatusCode, body, check)
+		}
+		if strings.HasPrefix(path, "/latency") {
+			var summaries []LatencySummary
+			if err := json.Unmarshal(body, &summaries); err != nil || len(summaries) != 4 {
+				t.Errorf("GET %s = %s, error = %v, want 4 summaries", path, body, err)
+			}
+		}
+	}
+}
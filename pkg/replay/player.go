@@ -0,0 +1,180 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Options controls a replay
+type Options struct {
+	// Speed scales the recorded timing, 2 replays twice as fast. Zero replays
+	// without waiting.
+	Speed float64
+	// Decode turns a recorded object into the replayed one, DecodeUnstructured
+	// by default.
+	Decode func(data []byte) (runtime.Object, error)
+}
+
+// DecodeUnstructured decodes objects for dynamic clients
+func DecodeUnstructured(data []byte) (runtime.Object, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// DecodeTyped decodes objects of the client-go scheme into their typed structs,
+// for typed clientsets and informers
+func DecodeTyped(data []byte) (runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	return obj, err
+}
+
+// ByKind keeps the entries of the given kinds
+func ByKind(entries []Entry, kinds ...string) []Entry {
+	var kept []Entry
+	for _, entry := range entries {
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(entry.Object, &meta); err != nil {
+			continue
+		}
+		if slices.Contains(kinds, meta.Kind) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// Replay streams the entries as a watch, waiting between them as recorded. The
+// watch stays open after the last entry until stopped or ctx is done, like a
+// quiet server would.
+func Replay(ctx context.Context, entries []Entry, opts Options) watch.Interface {
+	if opts.Decode == nil {
+		opts.Decode = DecodeUnstructured
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := &player{result: make(chan watch.Event), cancel: cancel}
+	go w.play(ctx, entries, opts)
+	return w
+}
+
+type player struct {
+	result chan watch.Event
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (w *player) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *player) Stop() {
+	w.once.Do(w.cancel)
+}
+
+func (w *player) play(ctx context.Context, entries []Entry, opts Options) {
+	defer close(w.result)
+	for i, entry := range entries {
+		if i > 0 && opts.Speed > 0 {
+			delay := time.Duration(float64(entry.Time.Sub(entries[i-1].Time)) / opts.Speed)
+			if delay > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+		obj, err := opts.Decode(entry.Object)
+		if err != nil {
+			slog.Error("decode recorded object failed", "entry", i, "error", err)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case w.result <- watch.Event{Type: entry.Type, Object: obj}:
+		}
+	}
+	<-ctx.Done()
+}
+
+// WatchReactor replays the entries to the first watch of a fake clientset,
+// later watches stay quiet so that reconnects do not replay twice:
+//
+//	clientset.PrependWatchReactor("services", replay.WatchReactor(ctx, entries, opts))
+func WatchReactor(ctx context.Context, entries []Entry, opts Options) clienttesting.WatchReactionFunc {
+	var once sync.Once
+	return func(clienttesting.Action) (bool, watch.Interface, error) {
+		replayed := false
+		once.Do(func() { replayed = true })
+		if !replayed {
+			return true, Replay(ctx, nil, opts), nil
+		}
+		return true, Replay(ctx, entries, opts), nil
+	}
+}
+
+// ListWatch lists nothing and replays the entries on the first watch, for
+// informers and watch helpers. The empty list is an UnstructuredList, or with
+// typed decoding the list type of the first entry's kind, so the entries of a
+// typed informer are of one kind, see ByKind.
+func ListWatch(entries []Entry, opts Options) *cache.ListWatch {
+	if opts.Decode == nil {
+		opts.Decode = DecodeUnstructured
+	}
+	var once sync.Once
+	return &cache.ListWatch{
+		ListWithContextFunc: func(context.Context, metav1.ListOptions) (runtime.Object, error) {
+			return emptyList(entries, opts.Decode)
+		},
+		WatchFuncWithContext: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			replayed := false
+			once.Do(func() { replayed = true })
+			if !replayed {
+				return Replay(ctx, nil, opts), nil
+			}
+			return Replay(ctx, entries, opts), nil
+		},
+	}
+}
+
+// emptyList returns an empty list of the decoded entries' type
+func emptyList(entries []Entry, decode func(data []byte) (runtime.Object, error)) (runtime.Object, error) {
+	var list runtime.Object = &unstructured.UnstructuredList{}
+	if len(entries) > 0 {
+		obj, err := decode(entries[0].Object)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := obj.(*unstructured.Unstructured); !ok {
+			gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+			if err != nil {
+				return nil, err
+			}
+			if list, err = scheme.Scheme.New(gvks[0].GroupVersion().WithKind(gvks[0].Kind + "List")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	accessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, err
+	}
+	accessor.SetResourceVersion("1")
+	return list, nil
+}
@@ -0,0 +1,186 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/urans/kubemaze/pkg/tour"
+)
+
+// recording returns events of services a and b and a configmap, 100ms apart
+func recording(t *testing.T) []Entry {
+	t.Helper()
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	start := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	tick := 0
+	r.now = func() time.Time {
+		tick++
+		return start.Add(time.Duration(tick) * 100 * time.Millisecond)
+	}
+	cm := &corev1.ConfigMap{}
+	cm.Namespace, cm.Name, cm.ResourceVersion = "shop", "settings", "3"
+	for _, e := range []struct {
+		typ watch.EventType
+		obj runtime.Object
+	}{
+		{watch.Added, recordedService("a", "1")},
+		{watch.Added, recordedService("b", "2")},
+		{watch.Added, cm},
+		{watch.Modified, recordedService("a", "4")},
+		{watch.Deleted, recordedService("b", "5")},
+	} {
+		if err := r.Record(e.typ, e.obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestReplay(t *testing.T) {
+	entries := recording(t)
+	tests := []struct {
+		name    string
+		speed   float64
+		minTime time.Duration
+		maxTime time.Duration
+	}{
+		{name: "original timing", speed: 1, minTime: 400 * time.Millisecond, maxTime: 5 * time.Second},
+		{name: "accelerated", speed: 8, minTime: 50 * time.Millisecond, maxTime: 350 * time.Millisecond},
+		{name: "no waiting", speed: 0, maxTime: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			w := Replay(context.Background(), entries, Options{Speed: tt.speed})
+			defer w.Stop()
+			var got []string
+			for range entries {
+				event := <-w.ResultChan()
+				obj := event.Object.(*unstructured.Unstructured)
+				got = append(got, string(event.Type)+" "+obj.GetKind()+" "+obj.GetName())
+			}
+			elapsed := time.Since(start)
+			want := []string{"ADDED Service a", "ADDED Service b", "ADDED ConfigMap settings", "MODIFIED Service a", "DELETED Service b"}
+			if !slices.Equal(got, want) {
+				t.Errorf("Replay() events = %v, want %v", got, want)
+			}
+			if elapsed < tt.minTime || elapsed > tt.maxTime {
+				t.Errorf("Replay() took %v, want between %v and %v", elapsed, tt.minTime, tt.maxTime)
+			}
+		})
+	}
+}
+
+func TestWatchReactor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clientset := fake.NewClientset()
+	clientset.PrependWatchReactor("services", WatchReactor(ctx, ByKind(recording(t), "Service"), Options{Decode: DecodeTyped}))
+
+	var mu sync.Mutex
+	var got []string
+	notified := make(chan struct{}, 10)
+	note := func(event string, obj any) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, event+" "+obj.(*corev1.Service).Name)
+		notified <- struct{}{}
+	}
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { note("add", obj) },
+		UpdateFunc: func(_, obj any) { note("update", obj) },
+		DeleteFunc: func(obj any) { note("delete", obj) },
+	})
+	factory.Start(ctx.Done())
+	defer func() {
+		cancel()
+		factory.Shutdown()
+	}()
+
+	want := []string{"add a", "add b", "update a", "delete b"}
+	for range want {
+		select {
+		case <-notified:
+		case <-ctx.Done():
+			t.Fatalf("informer timed out after %v", got)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(got, want) {
+		t.Errorf("informer notifications = %v, want %v", got, want)
+	}
+}
+
+func TestListWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := tour.Watch[*unstructured.Unstructured](ctx, ListWatch(recording(t), Options{}), tour.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	var got []string
+	for range 5 {
+		select {
+		case event := <-events:
+			got = append(got, string(event.Type)+" "+event.Object.GetName())
+		case <-ctx.Done():
+			t.Fatalf("Watch() timed out after %v", got)
+		}
+	}
+	want := []string{"ADDED a", "ADDED b", "ADDED settings", "MODIFIED a", "DELETED b"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Watch() events = %v, want %v", got, want)
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestListWatchTyped(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+		opts    Options
+		want    string
+	}{
+		{name: "unstructured", entries: recording(t), want: "*unstructured.UnstructuredList"},
+		{name: "typed", entries: ByKind(recording(t), "Service"), opts: Options{Decode: DecodeTyped}, want: "*v1.ServiceList"},
+		{name: "typed without entries", opts: Options{Decode: DecodeTyped}, want: "*unstructured.UnstructuredList"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := ListWatch(tt.entries, tt.opts).ListWithContext(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if got := fmt.Sprintf("%T", list); got != tt.want {
+				t.Errorf("List() = %s, want %s", got, tt.want)
+			}
+			if accessor, err := meta.ListAccessor(list); err != nil || accessor.GetResourceVersion() != "1" {
+				t.Errorf("List() resourceVersion = %v, error = %v, want 1", accessor, err)
+			}
+		})
+	}
+}
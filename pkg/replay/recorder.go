@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Entry is a recorded watch event, one JSON line of a recording
+type Entry struct {
+	Time   time.Time       `json:"time"`
+	Type   watch.EventType `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Recorder writes watch events with their full objects as JSON lines
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	scheme *runtime.Scheme
+	now    func() time.Time
+}
+
+// NewRecorder creates a recorder writing to w. Typed objects miss their
+// apiVersion and kind, which are looked up in the client-go scheme.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w), scheme: scheme.Scheme, now: time.Now}
+}
+
+// Record writes an event
+func (r *Recorder) Record(typ watch.EventType, obj runtime.Object) error {
+	if obj.GetObjectKind().GroupVersionKind().Empty() {
+		gvks, _, err := r.scheme.ObjectKinds(obj)
+		if err == nil && len(gvks) > 0 {
+			obj = obj.DeepCopyObject()
+			obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+		}
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(Entry{Time: r.now().UTC(), Type: typ, Object: data})
+}
+
+// RecordWatch records the events of w until it ends or ctx is done
+func (r *Recorder) RecordWatch(ctx context.Context, w watch.Interface) error {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Bookmark || event.Type == watch.Error {
+				continue
+			}
+			if err := r.Record(event.Type, event.Object); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// EventHandler records the notifications of an informer
+func (r *Recorder) EventHandler() cache.ResourceEventHandler {
+	record := func(typ watch.EventType, obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if obj, ok := obj.(runtime.Object); ok {
+			if err := r.Record(typ, obj); err != nil {
+				slog.Error("record event failed", "error", err)
+			}
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { record(watch.Added, obj) },
+		UpdateFunc: func(_, obj any) { record(watch.Modified, obj) },
+		DeleteFunc: func(obj any) { record(watch.Deleted, obj) },
+	}
+}
+
+// Load reads a recording
+func Load(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
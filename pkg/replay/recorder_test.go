@@ -0,0 +1,68 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func recordedService(name, rv string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, ResourceVersion: rv}}
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	start := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	tick := 0
+	r.now = func() time.Time {
+		tick++
+		return start.Add(time.Duration(tick) * time.Second)
+	}
+
+	w := watch.NewFakeWithChanSize(10, false)
+	w.Add(recordedService("web", "1"))
+	w.Action(watch.Bookmark, recordedService("", "2"))
+	w.Modify(recordedService("web", "3"))
+	w.Delete(recordedService("web", "4"))
+	w.Stop()
+	if err := r.RecordWatch(context.Background(), w); err != nil {
+		t.Fatalf("RecordWatch() error = %v", err)
+	}
+	r.EventHandler().OnAdd(recordedService("api", "5"), false)
+
+	entries, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := []string{"ADDED web 1", "MODIFIED web 3", "DELETED web 4", "ADDED api 5"}
+	if len(entries) != len(want) {
+		t.Fatalf("Load() = %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		obj, err := DecodeTyped(entry.Object)
+		if err != nil {
+			t.Fatalf("DecodeTyped() entry %d error = %v", i, err)
+		}
+		svc, ok := obj.(*corev1.Service)
+		if !ok {
+			t.Fatalf("DecodeTyped() entry %d = %T, want a Service", i, obj)
+		}
+		if got := string(entry.Type) + " " + svc.Name + " " + svc.ResourceVersion; got != want[i] {
+			t.Errorf("entry %d = %s, want %s", i, got, want[i])
+		}
+		if wantTime := start.Add(time.Duration(i+1) * time.Second); !entry.Time.Equal(wantTime) {
+			t.Errorf("entry %d time = %v, want %v", i, entry.Time, wantTime)
+		}
+	}
+
+	if _, err := Load(strings.NewReader("{\"type\":\"ADDED\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Load() invalid line error = %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+package tour
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	crdsGVR       = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+)
+
+// backupSkipped are resources regenerated by the cluster which must not be restored
+var backupSkipped = []string{"events", "endpoints", "endpointslices", "leases"}
+
+// restoreOrder ranks resources so dependencies are created before their users
+var restoreOrder = map[string]int{
+	"namespaces":                0,
+	"customresourcedefinitions": 1,
+	"serviceaccounts":           2,
+	"configmaps":                3,
+	"secrets":                   3,
+	"persistentvolumeclaims":    4,
+	"roles":                     4,
+	"rolebindings":              4,
+	"services":                  4,
+}
+
+const restoreOrderWorkloads = 5
+
+// BackupNamespace writes the namespace and every listable namespaced object in
+// it as YAML into a tar.gz archive, returning the number of objects written.
+func BackupNamespace(ctx context.Context, disc discovery.DiscoveryInterface, dyn dynamic.Interface, namespace string, w io.Writer) (int, error) {
+	all, err := discovery.ServerPreferredResources(disc)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		slog.Error("discover namespaced resources failed", "error", err)
+		return 0, err
+	}
+	if err != nil {
+		slog.Warn("some api groups are not discoverable", "error", err)
+	}
+	lists := discovery.FilteredBy(discovery.ResourcePredicateFunc(func(_ string, r *metav1.APIResource) bool {
+		return r.Namespaced && slices.Contains(r.Verbs, "list") && !slices.Contains(backupSkipped, r.Name)
+	}), all)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	count := 0
+
+	ns, err := dyn.Resource(namespacesGVR).Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("get namespace failed", "namespace", namespace, "error", err)
+		return 0, err
+	}
+	if err := writeBackupObject(tw, namespacesGVR, ns); err != nil {
+		return 0, err
+	}
+	count++
+
+	backedUp := map[string]bool{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return count, err
+		}
+		for _, res := range list.APIResources {
+			if strings.Contains(res.Name, "/") {
+				continue
+			}
+			gvr := gv.WithResource(res.Name)
+			objs, err := dyn.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				slog.Warn("list resource failed, skipped", "resource", gvr.String(), "error", err)
+				continue
+			}
+			for i := range objs.Items {
+				obj := &objs.Items[i]
+				if !backupWanted(obj) {
+					continue
+				}
+				if err := writeBackupObject(tw, gvr, obj); err != nil {
+					return count, err
+				}
+				count++
+				backedUp[res.Name+"."+gv.Group] = true
+			}
+		}
+	}
+
+	// Custom resources need their definitions restored first, a CRD is named
+	// after the plural and group of the resource it defines
+	crds, err := dyn.Resource(crdsGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		slog.Warn("list custom resource definitions failed, skipped", "error", err)
+		crds = &unstructured.UnstructuredList{}
+	}
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		if !backedUp[crd.GetName()] {
+			continue
+		}
+		if err := writeBackupObject(tw, crdsGVR, crd); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if err := tw.Close(); err != nil {
+		return count, err
+	}
+	if err := gz.Close(); err != nil {
+		return count, err
+	}
+	slog.Info("namespace backup written", "namespace", namespace, "objects", count)
+	return count, nil
+}
+
+// backupWanted drops objects managed by an owner or created by the cluster itself
+func backupWanted(obj *unstructured.Unstructured) bool {
+	if len(obj.GetOwnerReferences()) > 0 {
+		return false
+	}
+	switch {
+	case obj.GetKind() == "ConfigMap" && obj.GetName() == "kube-root-ca.crt":
+		return false
+	case obj.GetKind() == "ServiceAccount" && obj.GetName() == "default":
+		return false
+	case obj.GetKind() == "Secret":
+		t, _, _ := unstructured.NestedString(obj.Object, "type")
+		return t != "kubernetes.io/service-account-token"
+	}
+	return true
+}
+
+// sanitizeObject strips server populated fields which would make a restore fail
+func sanitizeObject(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range []string{"managedFields", "uid", "resourceVersion", "creationTimestamp", "generation", "selfLink", "ownerReferences"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	if obj.GetKind() == "Service" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+	}
+	// A restored claim binds or provisions anew, the volume it was bound to
+	// still belongs to the original claim
+	if obj.GetKind() == "PersistentVolumeClaim" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumeName")
+		annotations := obj.GetAnnotations()
+		for _, key := range []string{"pv.kubernetes.io/bind-completed", "pv.kubernetes.io/bound-by-controller", "volume.kubernetes.io/selected-node"} {
+			delete(annotations, key)
+		}
+		obj.SetAnnotations(annotations)
+	}
+}
+
+func backupPath(gvr schema.GroupVersionResource, name string) string {
+	group := gvr.Group
+	if group == "" {
+		group = "core"
+	}
+	return path.Join(group, gvr.Version, gvr.Resource, name+".yaml")
+}
+
+func parseBackupPath(p string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(p, "/")
+	if len(parts) != 4 || !strings.HasSuffix(parts[3], ".yaml") {
+		return schema.GroupVersionResource{}, fmt.Errorf("unexpected backup entry %q", p)
+	}
+	group := parts[0]
+	if group == "core" {
+		group = ""
+	}
+	return schema.GroupVersionResource{Group: group, Version: parts[1], Resource: parts[2]}, nil
+}
+
+func writeBackupObject(tw *tar.Writer, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	obj = obj.DeepCopy()
+	sanitizeObject(obj)
+	content, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return fmt.Errorf("marshal %s %s: %w", gvr.Resource, obj.GetName(), err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    backupPath(gvr, obj.GetName()),
+		Mode:    0o644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// RestoreOptions controls how a namespace backup is restored
+type RestoreOptions struct {
+	// TargetNamespace restores the objects into another namespace when set.
+	TargetNamespace string
+	// EstablishTimeout bounds the wait for a restored CRD to be served, 1m by default.
+	EstablishTimeout time.Duration
+}
+
+type restoreItem struct {
+	gvr schema.GroupVersionResource
+	obj *unstructured.Unstructured
+}
+
+// RestoreNamespace recreates the objects of a BackupNamespace archive in dependency
+// order, skipping objects which already exist. Restored CRDs are waited for until
+// established and discoverable before their custom resources get created. It
+// returns the number created.
+func RestoreNamespace(ctx context.Context, disc discovery.DiscoveryInterface, dyn dynamic.Interface, r io.Reader, opts RestoreOptions) (int, error) {
+	if opts.EstablishTimeout <= 0 {
+		opts.EstablishTimeout = time.Minute
+	}
+	items, err := readBackup(r)
+	if err != nil {
+		return 0, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disc))
+	sort.SliceStable(items, func(i, j int) bool {
+		return restoreRank(items[i].gvr) < restoreRank(items[j].gvr)
+	})
+
+	created := 0
+	for _, item := range items {
+		obj := item.obj
+		if opts.TargetNamespace != "" {
+			if item.gvr.Resource == "rolebindings" {
+				if err := retargetSubjects(obj, obj.GetNamespace(), opts.TargetNamespace); err != nil {
+					return created, err
+				}
+			}
+			if item.gvr == namespacesGVR {
+				obj.SetName(opts.TargetNamespace)
+			} else if obj.GetNamespace() != "" {
+				obj.SetNamespace(opts.TargetNamespace)
+			}
+		}
+
+		var client dynamic.ResourceInterface = dyn.Resource(item.gvr)
+		if obj.GetNamespace() != "" {
+			client = dyn.Resource(item.gvr).Namespace(obj.GetNamespace())
+		}
+		_, err := client.Create(ctx, obj, metav1.CreateOptions{})
+		if kerrs.IsAlreadyExists(err) {
+			slog.Warn("object already exists, skipped", "resource", item.gvr.String(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+			continue
+		}
+		if err != nil {
+			slog.Error("restore object failed", "resource", item.gvr.String(), "namespace", obj.GetNamespace(), "name", obj.GetName(), "error", err)
+			return created, err
+		}
+		created++
+		if item.gvr == crdsGVR {
+			if err := waitForCRD(ctx, dyn, mapper, obj, opts.EstablishTimeout); err != nil {
+				return created, err
+			}
+		}
+	}
+	slog.Info("namespace backup restored", "objects", created, "total", len(items))
+	return created, nil
+}
+
+// retargetSubjects moves the subjects of a RoleBinding living in the source
+// namespace, like its ServiceAccounts, to the target namespace
+func retargetSubjects(obj *unstructured.Unstructured, source, target string) error {
+	subjects, found, err := unstructured.NestedSlice(obj.Object, "subjects")
+	if err != nil || !found {
+		return err
+	}
+	for _, s := range subjects {
+		subject, ok := s.(map[string]any)
+		if ok && subject["namespace"] == source {
+			subject["namespace"] = target
+		}
+	}
+	return unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+}
+
+// waitForCRD polls a created CRD until it has the Established condition and the
+// refreshed mapper resolves its resource, so its custom resources can be created
+func waitForCRD(ctx context.Context, dyn dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, crd *unstructured.Unstructured, timeout time.Duration) error {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := dyn.Resource(crdsGVR).Get(ctx, crd.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if !crdEstablished(current) {
+			return false, nil
+		}
+		mapper.Reset()
+		_, err = mapper.ResourceFor(schema.GroupVersionResource{Group: group, Resource: plural})
+		return err == nil, nil
+	})
+	if err != nil {
+		slog.Error("wait for custom resource definition failed", "name", crd.GetName(), "error", err)
+		return fmt.Errorf("wait for crd %s established: %w", crd.GetName(), err)
+	}
+	return nil
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]any)
+		if ok && condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func restoreRank(gvr schema.GroupVersionResource) int {
+	if rank, ok := restoreOrder[gvr.Resource]; ok {
+		return rank
+	}
+	return restoreOrderWorkloads
+}
+
+func readBackup(r io.Reader) ([]restoreItem, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	items := []restoreItem{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read backup archive: %w", err)
+		}
+		gvr, err := parseBackupPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(content, &obj.Object); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", hdr.Name, err)
+		}
+		items = append(items, restoreItem{gvr: gvr, obj: obj})
+	}
+}
@@ -0,0 +1,267 @@
+package tour
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsGVR  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	servicesGVR    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	podsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	memcachedsGVR  = schema.GroupVersionResource{Group: "cache.urans.com", Version: "v1", Resource: "memcacheds"}
+	roleBindingGVR = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}
+)
+
+func backupListKinds() map[schema.GroupVersionResource]string {
+	return map[schema.GroupVersionResource]string{
+		namespacesGVR:  "NamespaceList",
+		crdsGVR:        "CustomResourceDefinitionList",
+		configMapsGVR:  "ConfigMapList",
+		servicesGVR:    "ServiceList",
+		podsGVR:        "PodList",
+		deploymentsGVR: "DeploymentList",
+		memcachedsGVR:  "MemcachedList",
+		roleBindingGVR: "RoleBindingList",
+	}
+}
+
+func backupDiscovery() *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"get", "list", "create"}},
+			{Name: "services", Namespaced: true, Kind: "Service", Verbs: []string{"get", "list", "create"}},
+			{Name: "pods", Namespaced: true, Kind: "Pod", Verbs: []string{"get", "list", "create"}},
+			{Name: "pods/log", Namespaced: true, Kind: "Pod", Verbs: []string{"get"}},
+			{Name: "namespaces", Namespaced: false, Kind: "Namespace", Verbs: []string{"get", "list"}},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: []string{"get", "list", "create"}},
+		}},
+		{GroupVersion: "rbac.authorization.k8s.io/v1", APIResources: []metav1.APIResource{
+			{Name: "rolebindings", Namespaced: true, Kind: "RoleBinding", Verbs: []string{"get", "list", "create"}},
+		}},
+		{GroupVersion: "cache.urans.com/v1", APIResources: []metav1.APIResource{
+			{Name: "memcacheds", Namespaced: true, Kind: "Memcached", Verbs: []string{"get", "list", "create"}},
+		}},
+	}}}
+}
+
+// establishCRDs makes the target cluster establish CRDs as soon as they are created
+func establishCRDs(target *dynamicfake.FakeDynamicClient) {
+	target.PrependReactor("create", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		crd := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		crd.Object["status"] = map[string]any{"conditions": []any{
+			map[string]any{"type": "Established", "status": "True"},
+		}}
+		return false, nil, nil
+	})
+}
+
+func newUnstructured(apiVersion, kind, namespace, name string, fields map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	for k, v := range fields {
+		obj.Object[k] = v
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(k8stypes.UID("uid-" + kind + "-" + name))
+	obj.SetResourceVersion("42")
+	return obj
+}
+
+func TestBackupRestoreNamespace(t *testing.T) {
+	pod := newUnstructured("v1", "Pod", "shop", "web-6f7c-abcde", nil)
+	pod.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-6f7c", UID: "rs"}})
+
+	source := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), backupListKinds(),
+		newUnstructured("v1", "Namespace", "", "shop", nil),
+		newUnstructured("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "memcacheds.cache.urans.com", map[string]any{
+			"spec": map[string]any{"group": "cache.urans.com", "names": map[string]any{"plural": "memcacheds", "kind": "Memcached"}},
+		}),
+		// A CRD of a built-in group name is not backed up with the deployments
+		newUnstructured("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets.apps", nil),
+		newUnstructured("v1", "ConfigMap", "shop", "settings", map[string]any{"data": map[string]any{"mode": "prod"}}),
+		newUnstructured("v1", "ConfigMap", "shop", "kube-root-ca.crt", nil),
+		newUnstructured("v1", "Service", "shop", "web", map[string]any{"spec": map[string]any{"clusterIP": "10.0.0.1"}}),
+		newUnstructured("apps/v1", "Deployment", "shop", "web", map[string]any{"status": map[string]any{"replicas": int64(3)}}),
+		newUnstructured("cache.urans.com/v1", "Memcached", "shop", "sessions", nil),
+		newUnstructured("rbac.authorization.k8s.io/v1", "RoleBinding", "shop", "deployer", map[string]any{
+			"subjects": []any{
+				map[string]any{"kind": "ServiceAccount", "namespace": "shop", "name": "deployer"},
+				map[string]any{"kind": "ServiceAccount", "namespace": "ci", "name": "runner"},
+			},
+		}),
+		newUnstructured("v1", "ConfigMap", "other", "unrelated", nil),
+		pod,
+	)
+	disc := backupDiscovery()
+
+	archive := &bytes.Buffer{}
+	written, err := BackupNamespace(context.Background(), disc, source, "shop", archive)
+	if err != nil {
+		t.Fatalf("BackupNamespace() error = %v", err)
+	}
+	// namespace, crd, settings, service, deployment, rolebinding, memcached
+	if written != 7 {
+		t.Errorf("BackupNamespace() written = %d, want 7", written)
+	}
+
+	items, err := readBackup(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("readBackup() error = %v", err)
+	}
+	for _, item := range items {
+		if item.obj.GetUID() != "" || item.obj.GetResourceVersion() != "" {
+			t.Errorf("backup of %s kept uid or resourceVersion", item.obj.GetName())
+		}
+		if _, ok := item.obj.Object["status"]; ok {
+			t.Errorf("backup of %s kept status", item.obj.GetName())
+		}
+	}
+
+	target := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), backupListKinds(),
+		newUnstructured("v1", "ConfigMap", "shop-restore", "settings", nil),
+	)
+	establishCRDs(target)
+	created, err := RestoreNamespace(context.Background(), disc, target, bytes.NewReader(archive.Bytes()), RestoreOptions{TargetNamespace: "shop-restore"})
+	if err != nil {
+		t.Fatalf("RestoreNamespace() error = %v", err)
+	}
+	if created != 6 {
+		t.Errorf("RestoreNamespace() created = %d, want 6", created)
+	}
+
+	order := []string{}
+	for _, action := range target.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			order = append(order, create.GetResource().Resource)
+			if ns := create.GetNamespace(); ns != "" && ns != "shop-restore" {
+				t.Errorf("RestoreNamespace() created %s in namespace %s", create.GetResource().Resource, ns)
+			}
+		}
+	}
+	// Resources of the same rank come in any order
+	sorted := slices.IsSortedFunc(order, func(a, b string) int {
+		return restoreRank(schema.GroupVersionResource{Resource: a}) - restoreRank(schema.GroupVersionResource{Resource: b})
+	})
+	if len(order) < 2 || order[0] != "namespaces" || order[1] != "customresourcedefinitions" || !sorted {
+		t.Fatalf("RestoreNamespace() create order = %v, want the namespace, the crds then by restore rank", order)
+	}
+
+	binding, err := target.Resource(roleBindingGVR).Namespace("shop-restore").Get(context.Background(), "deployer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("restored rolebinding error = %v", err)
+	}
+	subjects, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+	for i, want := range []string{"shop-restore", "ci"} {
+		if ns := subjects[i].(map[string]any)["namespace"]; ns != want {
+			t.Errorf("restored rolebinding subject %d namespace = %v, want %s", i, ns, want)
+		}
+	}
+
+	svc, err := target.Resource(servicesGVR).Namespace("shop-restore").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("restored service error = %v", err)
+	}
+	if _, found, _ := unstructured.NestedString(svc.Object, "spec", "clusterIP"); found {
+		t.Errorf("restored service kept its clusterIP")
+	}
+}
+
+func TestRestoreNamespaceCRDNotEstablished(t *testing.T) {
+	source := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), backupListKinds(),
+		newUnstructured("v1", "Namespace", "", "shop", nil),
+		newUnstructured("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "memcacheds.cache.urans.com", map[string]any{
+			"spec": map[string]any{"group": "cache.urans.com", "names": map[string]any{"plural": "memcacheds", "kind": "Memcached"}},
+		}),
+		newUnstructured("cache.urans.com/v1", "Memcached", "shop", "sessions", nil),
+	)
+	archive := &bytes.Buffer{}
+	if _, err := BackupNamespace(context.Background(), backupDiscovery(), source, "shop", archive); err != nil {
+		t.Fatalf("BackupNamespace() error = %v", err)
+	}
+
+	target := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), backupListKinds())
+	created, err := RestoreNamespace(context.Background(), backupDiscovery(), target, bytes.NewReader(archive.Bytes()),
+		RestoreOptions{EstablishTimeout: 50 * time.Millisecond})
+	if err == nil || created != 2 {
+		t.Errorf("RestoreNamespace() = %d, error = %v, want the namespace and crd then an error", created, err)
+	}
+	if _, err := target.Resource(memcachedsGVR).Namespace("shop").Get(context.Background(), "sessions", metav1.GetOptions{}); err == nil {
+		t.Errorf("RestoreNamespace() created a custom resource before its crd was established")
+	}
+}
+
+func TestSanitizeObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		obj     *unstructured.Unstructured
+		removed [][]string
+		kept    [][]string
+	}{
+		{
+			name:    "service",
+			obj:     newUnstructured("v1", "Service", "shop", "web", map[string]any{"spec": map[string]any{"clusterIP": "10.0.0.1", "clusterIPs": []any{"10.0.0.1"}, "type": "ClusterIP"}}),
+			removed: [][]string{{"metadata", "uid"}, {"metadata", "resourceVersion"}, {"spec", "clusterIP"}, {"spec", "clusterIPs"}},
+			kept:    [][]string{{"spec", "type"}},
+		},
+		{
+			name: "persistentvolumeclaim",
+			obj: newUnstructured("v1", "PersistentVolumeClaim", "shop", "data", map[string]any{
+				"metadata": map[string]any{"annotations": map[string]any{
+					"pv.kubernetes.io/bind-completed":      "yes",
+					"pv.kubernetes.io/bound-by-controller": "yes",
+					"volume.kubernetes.io/selected-node":   "node-1",
+					"team":                                 "payments",
+				}},
+				"spec":   map[string]any{"volumeName": "pvc-1234", "storageClassName": "standard"},
+				"status": map[string]any{"phase": "Bound"},
+			}),
+			removed: [][]string{
+				{"status"}, {"spec", "volumeName"},
+				{"metadata", "annotations", "pv.kubernetes.io/bind-completed"},
+				{"metadata", "annotations", "pv.kubernetes.io/bound-by-controller"},
+				{"metadata", "annotations", "volume.kubernetes.io/selected-node"},
+			},
+			kept: [][]string{{"spec", "storageClassName"}, {"metadata", "annotations", "team"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sanitizeObject(tt.obj)
+			for _, fields := range tt.removed {
+				if _, found, _ := unstructured.NestedFieldNoCopy(tt.obj.Object, fields...); found {
+					t.Errorf("sanitizeObject() kept %v", fields)
+				}
+			}
+			for _, fields := range tt.kept {
+				if _, found, _ := unstructured.NestedFieldNoCopy(tt.obj.Object, fields...); !found {
+					t.Errorf("sanitizeObject() removed %v", fields)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreNamespaceInvalidArchive(t *testing.T) {
+	target := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	if _, err := RestoreNamespace(context.Background(), backupDiscovery(), target, bytes.NewReader([]byte("not a tarball")), RestoreOptions{}); err == nil {
+		t.Errorf("RestoreNamespace() error = nil, want error")
+	}
+}
@@ -0,0 +1,207 @@
+package tour
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// CertInfo describes a certificate found in a secret or kubeconfig
+type CertInfo struct {
+	// Source locates the certificate, like secret shop/web-tls tls.crt.
+	Source   string    `json:"source"`
+	Subject  string    `json:"subject"`
+	SANs     []string  `json:"sans,omitempty"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+	Severity Severity  `json:"severity"`
+}
+
+// CertThresholds are the days left from which a certificate is a warning or critical
+type CertThresholds struct {
+	Warning  int
+	Critical int
+}
+
+// DefaultCertThresholds warns a month ahead and is critical in the last week
+var DefaultCertThresholds = CertThresholds{Warning: 30, Critical: 7}
+
+func (t CertThresholds) severity(daysLeft int) Severity {
+	switch {
+	case daysLeft <= t.Critical:
+		return SeverityCritical
+	case daysLeft <= t.Warning:
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+// ScanSecretCerts parses the kubernetes.io/tls secrets and any other secret data
+// holding PEM certificates in namespace, empty means all namespaces.
+func ScanSecretCerts(clientset kubernetes.Interface, namespace string, thresholds CertThresholds) ([]CertInfo, error) {
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		slog.Error("list secrets failed", "namespace", namespace, "error", err)
+		return nil, err
+	}
+	certs := []CertInfo{}
+	now := time.Now()
+	for _, secret := range secrets.Items {
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			source := fmt.Sprintf("secret %s/%s %s", secret.Namespace, secret.Name, key)
+			found := parsePEMCerts(source, secret.Data[key], now, thresholds)
+			if len(found) == 0 && secret.Type == corev1.SecretTypeTLS && key == corev1.TLSCertKey {
+				slog.Warn("tls secret holds no certificate", "namespace", secret.Namespace, "name", secret.Name)
+			}
+			certs = append(certs, found...)
+		}
+	}
+	return certs, nil
+}
+
+// ScanKubeconfigCerts parses the client certificates of the users and the
+// certificate authorities of the clusters in a kubeconfig file. Relative
+// certificate paths are relative to the kubeconfig file, like for clientcmd.
+func ScanKubeconfigCerts(kubeconfig string, thresholds CertThresholds) ([]CertInfo, error) {
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		slog.Error("load kubeconfig failed", "error", err)
+		return nil, err
+	}
+	if err := clientcmd.ResolveLocalPaths(config); err != nil {
+		slog.Error("resolve kubeconfig paths failed", "error", err)
+		return nil, err
+	}
+	certs := []CertInfo{}
+	now := time.Now()
+	load := func(data []byte, file string) []byte {
+		if len(data) > 0 || file == "" {
+			return data
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			slog.Warn("read certificate file failed", "file", file, "error", err)
+		}
+		return content
+	}
+
+	users := make([]string, 0, len(config.AuthInfos))
+	for name := range config.AuthInfos {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	for _, name := range users {
+		user := config.AuthInfos[name]
+		data := load(user.ClientCertificateData, user.ClientCertificate)
+		certs = append(certs, parsePEMCerts("kubeconfig user "+name, data, now, thresholds)...)
+	}
+
+	clusters := make([]string, 0, len(config.Clusters))
+	for name := range config.Clusters {
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	for _, name := range clusters {
+		cluster := config.Clusters[name]
+		data := load(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+		certs = append(certs, parsePEMCerts("kubeconfig cluster "+name+" ca", data, now, thresholds)...)
+	}
+	return certs, nil
+}
+
+// parsePEMCerts returns every CERTIFICATE block of data, other blocks and
+// non PEM data are ignored.
+func parsePEMCerts(source string, data []byte, now time.Time, thresholds CertThresholds) []CertInfo {
+	certs := []CertInfo{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			slog.Warn("parse certificate failed", "source", source, "error", err)
+			continue
+		}
+		sans := append([]string{}, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		sans = append(sans, cert.EmailAddresses...)
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		daysLeft := int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24))
+		certs = append(certs, CertInfo{
+			Source:   source,
+			Subject:  cert.Subject.String(),
+			SANs:     sans,
+			Issuer:   cert.Issuer.String(),
+			NotAfter: cert.NotAfter,
+			DaysLeft: daysLeft,
+			Severity: thresholds.severity(daysLeft),
+		})
+	}
+}
+
+// CertExitCode maps the worst certificate to a monitoring plugin exit code,
+// 0 when all are fine, 1 for a warning and 2 for a critical one.
+func CertExitCode(certs []CertInfo) int {
+	code := 0
+	for _, c := range certs {
+		switch c.Severity {
+		case SeverityCritical:
+			return 2
+		case SeverityWarning:
+			code = 1
+		}
+	}
+	return code
+}
+
+// WriteCertReport renders the certificates as table or json, soonest expiry first
+func WriteCertReport(w io.Writer, format string, certs []CertInfo) error {
+	certs = slices.Clone(certs)
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(certs)
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEVERITY\tDAYS\tNOT-AFTER\tSOURCE\tSUBJECT\tSANS\tISSUER")
+		for _, c := range certs {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", c.Severity, c.DaysLeft, c.NotAfter.Format(time.DateOnly),
+				c.Source, c.Subject, orDash(strings.Join(c.SANs, ",")), c.Issuer)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,228 @@
+package tour
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed deprecations.yaml
+var deprecationsYAML []byte
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// RemovedAPI is a GroupVersion and kind no longer served from a Kubernetes release on
+type RemovedAPI struct {
+	GroupVersion string `json:"groupVersion"`
+	Kind         string `json:"kind"`
+	Replacement  string `json:"replacement"`
+	RemovedIn    string `json:"removedIn"`
+}
+
+// APIFinding is an object, or part of one, using a removed API version
+type APIFinding struct {
+	// Source is the manifest file or "live" for objects read from the cluster.
+	Source string `json:"source"`
+	// Field tells where the version was found: apiVersion, last-applied or managedFields.
+	Field       string `json:"field"`
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+	APIVersion  string `json:"apiVersion"`
+	RemovedIn   string `json:"removedIn"`
+	Replacement string `json:"replacement"`
+}
+
+// DeprecationScanner finds API versions removed up to a target Kubernetes version
+type DeprecationScanner struct {
+	removed map[string]RemovedAPI
+}
+
+// NewDeprecationScanner loads the embedded deprecation table for target, like 1.29 or v1.29.3
+func NewDeprecationScanner(target string) (*DeprecationScanner, error) {
+	targetMinor, err := kubeMinor(target)
+	if err != nil {
+		return nil, err
+	}
+	table := map[string][]RemovedAPI{}
+	if err := yaml.Unmarshal(deprecationsYAML, &table); err != nil {
+		return nil, fmt.Errorf("decode deprecation table: %w", err)
+	}
+	s := &DeprecationScanner{removed: map[string]RemovedAPI{}}
+	for release, apis := range table {
+		minor, err := kubeMinor(release)
+		if err != nil {
+			return nil, fmt.Errorf("deprecation table: %w", err)
+		}
+		if minor > targetMinor {
+			continue
+		}
+		for _, api := range apis {
+			api.RemovedIn = release
+			s.removed[api.GroupVersion+"/"+api.Kind] = api
+		}
+	}
+	return s, nil
+}
+
+// kubeMinor returns the minor of a 1.x Kubernetes version
+func kubeMinor(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid kubernetes version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid kubernetes version %q", version)
+	}
+	return minor, nil
+}
+
+func (s *DeprecationScanner) lookup(apiVersion, kind string) (RemovedAPI, bool) {
+	api, ok := s.removed[apiVersion+"/"+kind]
+	return api, ok
+}
+
+// ScanManifests checks every object of a multi document YAML or JSON stream,
+// including the items of List objects.
+func (s *DeprecationScanner) ScanManifests(source string, r io.Reader) ([]APIFinding, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	findings := []APIFinding{}
+	for {
+		obj := map[string]any{}
+		err := decoder.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return findings, fmt.Errorf("decode %s: %w", source, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if u.IsList() {
+			err := u.EachListItem(func(item runtime.Object) error {
+				findings = append(findings, s.scanObject(source, item.(*unstructured.Unstructured))...)
+				return nil
+			})
+			if err != nil {
+				return findings, fmt.Errorf("decode %s: %w", source, err)
+			}
+			continue
+		}
+		findings = append(findings, s.scanObject(source, u)...)
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// ScanLive lists the cluster objects of every kind with a removed version, empty
+// namespace meaning all namespaces and cluster scoped objects, and checks the
+// versions they were last applied and managed with.
+func (s *DeprecationScanner) ScanLive(ctx context.Context, disc discovery.DiscoveryInterface, dyn dynamic.Interface, namespace string) ([]APIFinding, error) {
+	kinds := map[string]bool{}
+	for _, api := range s.removed {
+		kinds[api.Kind] = true
+	}
+	all, err := discovery.ServerPreferredResources(disc)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		slog.Error("discover resources failed", "error", err)
+		return nil, err
+	}
+	lists := discovery.FilteredBy(discovery.ResourcePredicateFunc(func(_ string, r *metav1.APIResource) bool {
+		return kinds[r.Kind] && slices.Contains(r.Verbs, "list") && !strings.Contains(r.Name, "/") &&
+			(namespace == "" || r.Namespaced)
+	}), all)
+
+	findings := []APIFinding{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return findings, err
+		}
+		for _, res := range list.APIResources {
+			gvr := gv.WithResource(res.Name)
+			var client dynamic.ResourceInterface = dyn.Resource(gvr)
+			if res.Namespaced {
+				client = dyn.Resource(gvr).Namespace(namespace)
+			}
+			objs, err := client.List(ctx, metav1.ListOptions{})
+			if err != nil {
+				slog.Warn("list resource failed, skipped", "resource", gvr.String(), "error", err)
+				continue
+			}
+			for i := range objs.Items {
+				findings = append(findings, s.scanObject("live", &objs.Items[i])...)
+			}
+		}
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+func (s *DeprecationScanner) scanObject(source string, obj *unstructured.Unstructured) []APIFinding {
+	findings := []APIFinding{}
+	seen := map[string]bool{}
+	report := func(field, apiVersion, kind string) {
+		api, ok := s.lookup(apiVersion, kind)
+		if !ok || seen[field+apiVersion] {
+			return
+		}
+		seen[field+apiVersion] = true
+		findings = append(findings, APIFinding{
+			Source:      source,
+			Field:       field,
+			Kind:        kind,
+			Namespace:   obj.GetNamespace(),
+			Name:        obj.GetName(),
+			APIVersion:  apiVersion,
+			RemovedIn:   api.RemovedIn,
+			Replacement: api.Replacement,
+		})
+	}
+
+	report("apiVersion", obj.GetAPIVersion(), obj.GetKind())
+	if applied, ok := obj.GetAnnotations()[lastAppliedAnnotation]; ok {
+		last := metav1.TypeMeta{}
+		if err := json.Unmarshal([]byte(applied), &last); err != nil {
+			slog.Warn("invalid last applied configuration", "kind", obj.GetKind(), "namespace", obj.GetNamespace(), "name", obj.GetName(), "error", err)
+		} else {
+			report("last-applied", last.APIVersion, last.Kind)
+		}
+	}
+	for _, mf := range obj.GetManagedFields() {
+		report("managedFields", mf.APIVersion, obj.GetKind())
+	}
+	return findings
+}
+
+func sortFindings(findings []APIFinding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Kind+"/"+a.Name < b.Kind+"/"+b.Name
+	})
+}
@@ -0,0 +1,128 @@
+package tour
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const deprecatedManifests = `
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  namespace: shop
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1beta1
+  kind: CronJob
+  metadata:
+    name: report
+    namespace: shop
+- apiVersion: policy/v1beta1
+  kind: PodSecurityPolicy
+  metadata:
+    name: restricted
+`
+
+func findingKeys(findings []APIFinding) []string {
+	keys := []string{}
+	for _, f := range findings {
+		keys = append(keys, f.Field+" "+f.Kind+"/"+f.Name+" "+f.APIVersion+" -> "+f.Replacement)
+	}
+	return keys
+}
+
+func TestDeprecationScannerManifests(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"1.21", []string{}},
+		{"v1.22.4", []string{"apiVersion Ingress/web extensions/v1beta1 -> networking.k8s.io/v1"}},
+		{"1.25", []string{
+			"apiVersion PodSecurityPolicy/restricted policy/v1beta1 -> ",
+			"apiVersion CronJob/report batch/v1beta1 -> batch/v1",
+			"apiVersion Ingress/web extensions/v1beta1 -> networking.k8s.io/v1",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			s, err := NewDeprecationScanner(tt.target)
+			if err != nil {
+				t.Fatalf("NewDeprecationScanner() error = %v", err)
+			}
+			got, err := s.ScanManifests("manifests.yaml", strings.NewReader(deprecatedManifests))
+			if err != nil {
+				t.Fatalf("ScanManifests() error = %v", err)
+			}
+			keys := findingKeys(got)
+			if strings.Join(keys, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ScanManifests() got = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewDeprecationScanner("2.0"); err == nil {
+		t.Errorf("NewDeprecationScanner() invalid version error = nil")
+	}
+}
+
+func TestDeprecationScannerLive(t *testing.T) {
+	hpaGVR := schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+	hpa := newUnstructured("autoscaling/v2", "HorizontalPodAutoscaler", "shop", "web", nil)
+	hpa.SetAnnotations(map[string]string{
+		lastAppliedAnnotation: `{"apiVersion":"autoscaling/v2beta2","kind":"HorizontalPodAutoscaler"}`,
+	})
+	hpa.SetManagedFields([]metav1.ManagedFieldsEntry{
+		{Manager: "kubectl", APIVersion: "autoscaling/v2beta2"},
+		{Manager: "kube-controller-manager", APIVersion: "autoscaling/v2"},
+	})
+	current := newUnstructured("autoscaling/v2", "HorizontalPodAutoscaler", "shop", "api", nil)
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{hpaGVR: "HorizontalPodAutoscalerList"}, hpa, current)
+	disc := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+		{GroupVersion: "autoscaling/v2", APIResources: []metav1.APIResource{
+			{Name: "horizontalpodautoscalers", Namespaced: true, Kind: "HorizontalPodAutoscaler", Verbs: []string{"get", "list"}},
+			{Name: "horizontalpodautoscalers/status", Namespaced: true, Kind: "HorizontalPodAutoscaler", Verbs: []string{"get"}},
+		}},
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"get", "list"}},
+		}},
+	}}}
+
+	s, err := NewDeprecationScanner("1.26")
+	if err != nil {
+		t.Fatalf("NewDeprecationScanner() error = %v", err)
+	}
+	got, err := s.ScanLive(context.Background(), disc, dyn, "shop")
+	if err != nil {
+		t.Fatalf("ScanLive() error = %v", err)
+	}
+	want := []string{
+		"last-applied HorizontalPodAutoscaler/web autoscaling/v2beta2 -> autoscaling/v2",
+		"managedFields HorizontalPodAutoscaler/web autoscaling/v2beta2 -> autoscaling/v2",
+	}
+	if keys := findingKeys(got); strings.Join(keys, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ScanLive() got = %q, want %q", keys, want)
+	}
+	if got[0].Source != "live" || got[0].RemovedIn != "1.26" {
+		t.Errorf("ScanLive() finding = %+v, want live source removed in 1.26", got[0])
+	}
+}
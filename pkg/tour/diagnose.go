@@ -0,0 +1,323 @@
+package tour
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Severity ranks how much a finding contributes to a pod not being Ready
+type Severity string
+
+const (
+	SeverityInfo     Severity = "Info"
+	SeverityWarning  Severity = "Warning"
+	SeverityCritical Severity = "Critical"
+)
+
+// Finding is a single diagnosed problem of a pod with a suggested remedy
+type Finding struct {
+	Severity  Severity `json:"severity"`
+	Container string   `json:"container,omitempty"`
+	Reason    string   `json:"reason"`
+	Message   string   `json:"message"`
+	Remedy    string   `json:"remedy,omitempty"`
+}
+
+// DiagnosePod explains why a pod is not Ready from its status, events and volume claims
+func DiagnosePod(clientset kubernetes.Interface, namespace, name string) ([]Finding, error) {
+	pod, err := GetPod(clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := clientset.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": name}.String(),
+	})
+	if err != nil {
+		slog.Error("list pod events failed", "namespace", namespace, "name", name, "error", err)
+		return nil, err
+	}
+
+	claims := make(map[string]*corev1.PersistentVolumeClaim)
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim := v.PersistentVolumeClaim.ClaimName
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), claim, metav1.GetOptions{})
+		if kerrs.IsNotFound(err) {
+			claims[claim] = nil
+			continue
+		}
+		if err != nil {
+			slog.Error("get pvc failed", "namespace", namespace, "name", claim, "error", err)
+			return nil, err
+		}
+		claims[claim] = pvc
+	}
+	return diagnosePod(pod, events.Items, claims), nil
+}
+
+// diagnosePod inspects the pod only through its arguments, a nil claim means it does not exist
+func diagnosePod(pod *corev1.Pod, events []corev1.Event, claims map[string]*corev1.PersistentVolumeClaim) []Finding {
+	events = podEvents(pod, events)
+	findings := []Finding{}
+	findings = append(findings, diagnoseScheduling(pod, events)...)
+	findings = append(findings, diagnoseClaims(claims)...)
+	for _, s := range pod.Status.InitContainerStatuses {
+		findings = append(findings, diagnoseContainer(s, true)...)
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		findings = append(findings, diagnoseContainer(s, false)...)
+	}
+	findings = append(findings, diagnoseEvents(pod, events)...)
+
+	if len(findings) == 0 {
+		if podConditionTrue(pod, corev1.PodReady) {
+			findings = append(findings, Finding{
+				Severity: SeverityInfo,
+				Reason:   "Ready",
+				Message:  "pod is Ready",
+			})
+		} else {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Reason:   "NotReady",
+				Message:  fmt.Sprintf("pod is %s without a known cause", pod.Status.Phase),
+				Remedy:   "inspect the pod conditions and recent events",
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) > severityRank(findings[j].Severity)
+	})
+	return findings
+}
+
+func severityRank(s Severity) int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
+
+func podCondition(pod *corev1.Pod, t corev1.PodConditionType) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == t {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func podConditionTrue(pod *corev1.Pod, t corev1.PodConditionType) bool {
+	c := podCondition(pod, t)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+func diagnoseScheduling(pod *corev1.Pod, events []corev1.Event) []Finding {
+	c := podCondition(pod, corev1.PodScheduled)
+	if c == nil || c.Status == corev1.ConditionTrue {
+		return nil
+	}
+	message := c.Message
+	if e := latestEvent(events, "FailedScheduling"); e != nil {
+		message = e.Message
+	}
+	return []Finding{{
+		Severity: SeverityCritical,
+		Reason:   "Unschedulable",
+		Message:  message,
+		Remedy:   "check node capacity, taints and tolerations, node selectors and affinity rules",
+	}}
+}
+
+func diagnoseClaims(claims map[string]*corev1.PersistentVolumeClaim) []Finding {
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	findings := []Finding{}
+	for _, name := range names {
+		pvc := claims[name]
+		if pvc == nil {
+			findings = append(findings, Finding{
+				Severity: SeverityCritical,
+				Reason:   "PVCNotFound",
+				Message:  fmt.Sprintf("persistentvolumeclaim %q does not exist", name),
+				Remedy:   "create the claim or fix the claimName of the volume",
+			})
+			continue
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			findings = append(findings, Finding{
+				Severity: SeverityCritical,
+				Reason:   "PVCNotBound",
+				Message:  fmt.Sprintf("persistentvolumeclaim %q is %s", name, pvc.Status.Phase),
+				Remedy:   "check the storage class provisioner and that a matching volume exists",
+			})
+		}
+	}
+	return findings
+}
+
+func diagnoseContainer(s corev1.ContainerStatus, init bool) []Finding {
+	findings := []Finding{}
+	if w := s.State.Waiting; w != nil {
+		f := Finding{Severity: SeverityWarning, Container: s.Name, Reason: w.Reason, Message: w.Message}
+		switch w.Reason {
+		case "CrashLoopBackOff":
+			f.Severity = SeverityCritical
+			f.Message = fmt.Sprintf("container restarted %d times", s.RestartCount)
+			f.Remedy = "read the previous container logs and check the command, config and probes"
+			if t := s.LastTerminationState.Terminated; t != nil {
+				f.Message += ", last " + describeTermination(t)
+				if t.Reason == "OOMKilled" {
+					f.Remedy = exitCodeRemedy(t)
+				}
+			}
+		case "ImagePullBackOff", "ErrImagePull", "InvalidImageName", "ErrImageNeverPull":
+			f.Severity = SeverityCritical
+			f.Remedy = "verify the image name and tag exist and the imagePullSecrets grant access"
+		case "CreateContainerConfigError", "CreateContainerError":
+			f.Severity = SeverityCritical
+			f.Remedy = "check that referenced ConfigMaps, Secrets and keys exist"
+		case "ContainerCreating", "PodInitializing":
+			f.Severity = SeverityInfo
+		}
+		findings = append(findings, f)
+	}
+
+	if t := s.State.Terminated; t != nil && (t.ExitCode != 0 || t.Reason == "OOMKilled") {
+		findings = append(findings, Finding{
+			Severity:  SeverityCritical,
+			Container: s.Name,
+			Reason:    terminationReason(t),
+			Message:   describeTermination(t),
+			Remedy:    exitCodeRemedy(t),
+		})
+	} else if t := s.LastTerminationState.Terminated; t != nil && t.Reason == "OOMKilled" && s.State.Waiting == nil {
+		findings = append(findings, Finding{
+			Severity:  SeverityWarning,
+			Container: s.Name,
+			Reason:    "OOMKilled",
+			Message:   "previous instance " + describeTermination(t),
+			Remedy:    exitCodeRemedy(t),
+		})
+	}
+
+	if !init && s.State.Running != nil && !s.Ready {
+		findings = append(findings, Finding{
+			Severity:  SeverityWarning,
+			Container: s.Name,
+			Reason:    "ContainerNotReady",
+			Message:   "container is running but not ready",
+			Remedy:    "check the readiness probe endpoint and the time the app needs to start",
+		})
+	}
+	return findings
+}
+
+func terminationReason(t *corev1.ContainerStateTerminated) string {
+	if t.Reason != "" {
+		return t.Reason
+	}
+	return "Terminated"
+}
+
+func describeTermination(t *corev1.ContainerStateTerminated) string {
+	if t.Signal != 0 {
+		return fmt.Sprintf("terminated with %s, exit code %d, signal %d", terminationReason(t), t.ExitCode, t.Signal)
+	}
+	return fmt.Sprintf("terminated with %s, exit code %d", terminationReason(t), t.ExitCode)
+}
+
+func exitCodeRemedy(t *corev1.ContainerStateTerminated) string {
+	if t.Reason == "OOMKilled" {
+		return "raise the memory limit or reduce the memory usage of the app"
+	}
+	switch t.ExitCode {
+	case 126:
+		return "the command is not executable, check file permissions in the image"
+	case 127:
+		return "the command was not found, check the image entrypoint and command"
+	case 137:
+		return "the container was killed by SIGKILL, check memory limits and liveness probes"
+	case 143:
+		return "the container was stopped by SIGTERM, check liveness probes and graceful shutdown"
+	}
+	return "read the container logs for the application error"
+}
+
+func diagnoseEvents(pod *corev1.Pod, events []corev1.Event) []Finding {
+	findings := []Finding{}
+	remedies := map[string]string{
+		"FailedMount":        "check the volume sources exist and the node can attach them",
+		"FailedAttachVolume": "check the volume is not attached to another node",
+		"Unhealthy":          "check the probe settings against the app startup and response times",
+	}
+	for _, reason := range []string{"FailedMount", "FailedAttachVolume", "Unhealthy"} {
+		e := latestEvent(events, reason)
+		if e == nil {
+			continue
+		}
+		findings = append(findings, Finding{
+			Severity: SeverityWarning,
+			Reason:   reason,
+			Message:  fmt.Sprintf("%s (x%d)", e.Message, max(e.Count, 1)),
+			Remedy:   remedies[reason],
+		})
+	}
+	return findings
+}
+
+// podEvents keeps the events of pod, leaving out those of an earlier pod of the
+// same name. Events without a UID are kept.
+func podEvents(pod *corev1.Pod, events []corev1.Event) []corev1.Event {
+	kept := make([]corev1.Event, 0, len(events))
+	for _, e := range events {
+		if e.InvolvedObject.UID == "" || e.InvolvedObject.UID == pod.UID {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func latestEvent(events []corev1.Event, reason string) *corev1.Event {
+	var latest *corev1.Event
+	for i := range events {
+		e := &events[i]
+		if e.Reason != reason {
+			continue
+		}
+		if latest == nil || eventTime(e).After(eventTime(latest)) {
+			latest = e
+		}
+	}
+	return latest
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
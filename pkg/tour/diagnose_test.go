@@ -0,0 +1,166 @@
+package tour
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func diagPod(mutate func(*corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "web-uid"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "app",
+				Ready: true,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+func TestDiagnosePod(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    []string
+		wantSev Severity
+	}{
+		{"ready", []runtime.Object{diagPod(nil)}, []string{"Ready"}, SeverityInfo},
+		{"crashloop-oom", []runtime.Object{diagPod(func(p *corev1.Pod) {
+			p.Status.ContainerStatuses[0] = corev1.ContainerStatus{
+				Name:                 "app",
+				RestartCount:         5,
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+			}
+		})}, []string{"CrashLoopBackOff"}, SeverityCritical},
+		{"image-pull", []runtime.Object{diagPod(func(p *corev1.Pod) {
+			p.Status.ContainerStatuses[0].State = corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"},
+			}
+		})}, []string{"ImagePullBackOff"}, SeverityCritical},
+		{"exit-code", []runtime.Object{diagPod(func(p *corev1.Pod) {
+			p.Status.ContainerStatuses[0].State = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 127},
+			}
+		})}, []string{"Error"}, SeverityCritical},
+		{"unschedulable", []runtime.Object{
+			diagPod(func(p *corev1.Pod) {
+				p.Status.Phase = corev1.PodPending
+				p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable"}}
+				p.Status.ContainerStatuses = nil
+			}),
+			&corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "web.1"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web", Namespace: "default"},
+				Reason:         "FailedScheduling",
+				Message:        "0/3 nodes are available: 3 Insufficient memory.",
+			},
+		}, []string{"Unschedulable"}, SeverityCritical},
+		{"unbound-pvc", []runtime.Object{
+			diagPod(func(p *corev1.Pod) {
+				p.Status.Phase = corev1.PodPending
+				p.Status.ContainerStatuses = nil
+				p.Status.Conditions = nil
+				p.Spec.Volumes = []corev1.Volume{
+					{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+					{Name: "logs", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"}}},
+				}
+			}),
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"},
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+			},
+		}, []string{"PVCNotBound", "PVCNotFound"}, SeverityCritical},
+		{"not-ready", []runtime.Object{
+			diagPod(func(p *corev1.Pod) {
+				p.Status.Conditions[1].Status = corev1.ConditionFalse
+				p.Status.ContainerStatuses[0].Ready = false
+			}),
+			&corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "web.2"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web", Namespace: "default", UID: "web-uid"},
+				Reason:         "Unhealthy",
+				Message:        "Readiness probe failed: HTTP probe failed with statuscode: 503",
+				Count:          12,
+			},
+		}, []string{"ContainerNotReady", "Unhealthy"}, SeverityWarning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiagnosePod(fake.NewClientset(tt.objects...), "default", "web")
+			if err != nil {
+				t.Fatalf("DiagnosePod() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("DiagnosePod() got = %+v, want reasons %v", got, tt.want)
+			}
+			for i, f := range got {
+				if f.Reason != tt.want[i] {
+					t.Errorf("DiagnosePod() finding %d reason = %s, want %s", i, f.Reason, tt.want[i])
+				}
+				if f.Severity != SeverityInfo && f.Remedy == "" {
+					t.Errorf("DiagnosePod() finding %d has no remedy", i)
+				}
+			}
+			if got[0].Severity != tt.wantSev {
+				t.Errorf("DiagnosePod() severity = %s, want %s", got[0].Severity, tt.wantSev)
+			}
+		})
+	}
+}
+
+func TestDiagnosePodRecreated(t *testing.T) {
+	pod := diagPod(func(p *corev1.Pod) {
+		p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Message: "condition"}}
+		p.Status.ContainerStatuses = nil
+	})
+	event := func(uid types.UID, reason, message string, minutes int) corev1.Event {
+		return corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web", Namespace: "default", UID: uid},
+			Reason:         reason,
+			Message:        message,
+			LastTimestamp:  metav1.NewTime(time.Date(2026, 10, 19, 8, minutes, 0, 0, time.UTC)),
+		}
+	}
+	// The newer events are of an earlier pod named web
+	events := []corev1.Event{
+		event("web-uid", "FailedScheduling", "current", 1),
+		event("old-uid", "FailedScheduling", "stale", 2),
+		event("web-uid", "FailedMount", "current", 1),
+		event("old-uid", "FailedMount", "stale", 2),
+		event("old-uid", "Unhealthy", "stale", 2),
+	}
+
+	got := diagnosePod(pod, events, nil)
+	want := []string{"Unschedulable: current", "FailedMount: current (x1)"}
+	if len(got) != len(want) {
+		t.Fatalf("diagnosePod() = %+v, want %v", got, want)
+	}
+	for i, f := range got {
+		if msg := f.Reason + ": " + f.Message; msg != want[i] {
+			t.Errorf("diagnosePod() finding %d = %s, want %s", i, msg, want[i])
+		}
+	}
+}
+
+func TestDiagnosePodNotFound(t *testing.T) {
+	if _, err := DiagnosePod(fake.NewClientset(), "default", "web"); err == nil {
+		t.Errorf("DiagnosePod() error = nil, want error")
+	}
+}
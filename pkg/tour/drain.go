@@ -0,0 +1,240 @@
+package tour
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/urans/kubemaze/pkg/recipe"
+)
+
+// CordonNode marks the node unschedulable
+func CordonNode(ctx context.Context, clientset kubernetes.Interface, name string) error {
+	return setUnschedulable(ctx, clientset, name, true)
+}
+
+// UncordonNode marks the node schedulable again
+func UncordonNode(ctx context.Context, clientset kubernetes.Interface, name string) error {
+	return setUnschedulable(ctx, clientset, name, false)
+}
+
+func setUnschedulable(ctx context.Context, clientset kubernetes.Interface, name string, unschedulable bool) error {
+	node, err := clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("get node failed", "name", name, "error", err)
+		return err
+	}
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	patch := fmt.Appendf(nil, `{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err = clientset.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		slog.Error("patch node failed", "name", name, "unschedulable", unschedulable, "error", err)
+		return err
+	}
+	slog.Info("node patched", "name", name, "unschedulable", unschedulable)
+	return nil
+}
+
+// DrainPhase is the stage a pod reached while draining a node
+type DrainPhase string
+
+const (
+	DrainSkipped  DrainPhase = "Skipped"
+	DrainEvicting DrainPhase = "Evicting"
+	DrainBlocked  DrainPhase = "Blocked"
+	DrainEvicted  DrainPhase = "Evicted"
+	DrainFailed   DrainPhase = "Failed"
+)
+
+// DrainEvent reports the progress of a single pod eviction
+type DrainEvent struct {
+	Node      string
+	Namespace string
+	Pod       string
+	Phase     DrainPhase
+	Message   string
+}
+
+// DrainOptions controls how pods are evicted from a node
+type DrainOptions struct {
+	// DeleteEmptyDirData evicts pods using emptyDir volumes, whose data is lost.
+	DeleteEmptyDirData bool
+	// BatchSize is the initial number of evictions in flight, it doubles
+	// after every successful batch like recipe.SlowStartBatch.
+	BatchSize int
+	// PodTimeout bounds the eviction and deletion of a single pod.
+	PodTimeout time.Duration
+	// Timeout bounds the whole drain, zero means no limit.
+	Timeout time.Duration
+	// RetryInterval is the wait between evictions refused by a PodDisruptionBudget.
+	RetryInterval time.Duration
+	// Events receives progress events when set, sends give up once the drain
+	// context is done.
+	Events chan<- DrainEvent
+}
+
+func (o *DrainOptions) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1
+	}
+	if o.PodTimeout <= 0 {
+		o.PodTimeout = 5 * time.Minute
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = 5 * time.Second
+	}
+}
+
+// DrainNode cordons the node and evicts its pods through the Eviction API,
+// skipping DaemonSet, mirror and completed pods.
+func DrainNode(ctx context.Context, clientset kubernetes.Interface, name string, opts DrainOptions) error {
+	opts.setDefaults()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if err := CordonNode(ctx, clientset, name); err != nil {
+		return err
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + name,
+	})
+	if err != nil {
+		slog.Error("list node pods failed", "node", name, "error", err)
+		return err
+	}
+
+	d := &drainer{clientset: clientset, node: name, opts: opts}
+	evictions := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != name {
+			continue
+		}
+		if reason := skipReason(&pod); reason != "" {
+			d.emit(ctx, &pod, DrainSkipped, reason)
+			continue
+		}
+		if hasEmptyDir(&pod) && !opts.DeleteEmptyDirData {
+			d.emit(ctx, &pod, DrainFailed, "pod uses emptyDir volumes")
+			return fmt.Errorf("drain node %s: pod %s/%s uses emptyDir volumes, set DeleteEmptyDirData to evict it",
+				name, pod.Namespace, pod.Name)
+		}
+		evictions = append(evictions, pod)
+	}
+
+	next := atomic.Int64{}
+	evicted, err := recipe.SlowStartBatch(len(evictions), opts.BatchSize, func() error {
+		pod := &evictions[next.Add(1)-1]
+		return d.evict(ctx, pod)
+	})
+	slog.Info("node drained", "node", name, "evicted", evicted, "total", len(evictions), "error", err)
+	return err
+}
+
+func skipReason(pod *corev1.Pod) string {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return "mirror pod"
+	}
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return "managed by DaemonSet " + ref.Name
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return "pod completed"
+	}
+	return ""
+}
+
+func hasEmptyDir(pod *corev1.Pod) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type drainer struct {
+	clientset kubernetes.Interface
+	node      string
+	opts      DrainOptions
+}
+
+func (d *drainer) emit(ctx context.Context, pod *corev1.Pod, phase DrainPhase, message string) {
+	slog.Debug("drain progress", "node", d.node, "namespace", pod.Namespace, "pod", pod.Name, "phase", phase, "message", message)
+	if d.opts.Events == nil {
+		return
+	}
+	event := DrainEvent{
+		Node:      d.node,
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Phase:     phase,
+		Message:   message,
+	}
+	select {
+	case d.opts.Events <- event:
+	case <-ctx.Done():
+	}
+}
+
+// evict retries the eviction while a PodDisruptionBudget refuses it with 429,
+// then waits until the pod is gone. Events are sent under the drain context so
+// the pod timeout does not drop the failure.
+func (d *drainer) evict(ctx context.Context, pod *corev1.Pod) error {
+	podCtx, cancel := context.WithTimeout(ctx, d.opts.PodTimeout)
+	defer cancel()
+
+	d.emit(ctx, pod, DrainEvicting, "")
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	for {
+		err := d.clientset.PolicyV1().Evictions(pod.Namespace).Evict(podCtx, eviction)
+		if err == nil || kerrs.IsNotFound(err) {
+			break
+		}
+		if !kerrs.IsTooManyRequests(err) {
+			d.emit(ctx, pod, DrainFailed, err.Error())
+			return fmt.Errorf("evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		d.emit(ctx, pod, DrainBlocked, err.Error())
+		select {
+		case <-podCtx.Done():
+			d.emit(ctx, pod, DrainFailed, "timed out waiting for disruption budget")
+			return fmt.Errorf("evict pod %s/%s: %w", pod.Namespace, pod.Name, podCtx.Err())
+		case <-time.After(d.opts.RetryInterval):
+		}
+	}
+
+	err := wait.PollUntilContextCancel(podCtx, d.opts.RetryInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := d.clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if kerrs.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return current.UID != pod.UID, nil
+	})
+	if err != nil {
+		d.emit(ctx, pod, DrainFailed, "timed out waiting for pod deletion")
+		return fmt.Errorf("wait pod %s/%s deleted: %w", pod.Namespace, pod.Name, err)
+	}
+	d.emit(ctx, pod, DrainEvicted, "")
+	return nil
+}
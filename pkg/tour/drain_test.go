@@ -0,0 +1,195 @@
+package tour
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func nodePod(name, node string, mutate func(*corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: k8stypes.UID("uid-" + name)},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+// evictionReactor deletes evicted pods, refusing each pod in budgets the given times with 429
+func evictionReactor(clientset *fake.Clientset, budgets map[string]int) k8stesting.ReactionFunc {
+	mu := sync.Mutex{}
+	podsGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if budgets[eviction.Name] > 0 {
+			budgets[eviction.Name]--
+			return true, nil, kerrs.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return true, nil, clientset.Tracker().Delete(podsGVR, eviction.Namespace, eviction.Name)
+	}
+}
+
+func TestDrainNode(t *testing.T) {
+	emptyDir := func(p *corev1.Pod) {
+		p.Spec.Volumes = []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	}
+	daemon := func(p *corev1.Pod) {
+		controller := true
+		p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "kube-proxy", Controller: &controller}}
+	}
+	mirror := func(p *corev1.Pod) {
+		p.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+	}
+	completed := func(p *corev1.Pod) {
+		p.Status.Phase = corev1.PodSucceeded
+	}
+
+	tests := []struct {
+		name        string
+		pods        []runtime.Object
+		budgets     map[string]int
+		opts        DrainOptions
+		wantErr     bool
+		wantRemains []string
+		wantBlocked int
+	}{
+		{
+			name: "evict-and-skip",
+			pods: []runtime.Object{
+				nodePod("web-0", "node-a", nil),
+				nodePod("web-1", "node-a", nil),
+				nodePod("proxy", "node-a", daemon),
+				nodePod("apiserver", "node-a", mirror),
+				nodePod("migrate", "node-a", completed),
+				nodePod("other", "node-b", nil),
+			},
+			budgets:     map[string]int{"web-1": 2},
+			opts:        DrainOptions{BatchSize: 2},
+			wantRemains: []string{"apiserver", "migrate", "other", "proxy"},
+			wantBlocked: 2,
+		},
+		{
+			name:        "empty-dir-refused",
+			pods:        []runtime.Object{nodePod("cache", "node-a", emptyDir)},
+			wantErr:     true,
+			wantRemains: []string{"cache"},
+		},
+		{
+			name:        "empty-dir-deleted",
+			pods:        []runtime.Object{nodePod("cache", "node-a", emptyDir)},
+			opts:        DrainOptions{DeleteEmptyDirData: true},
+			wantRemains: []string{},
+		},
+		{
+			name:        "budget-timeout",
+			pods:        []runtime.Object{nodePod("web-0", "node-a", nil)},
+			budgets:     map[string]int{"web-0": 1000},
+			opts:        DrainOptions{PodTimeout: 50 * time.Millisecond},
+			wantErr:     true,
+			wantRemains: []string{"web-0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append([]runtime.Object{&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}}, tt.pods...)
+			clientset := fake.NewClientset(objects...)
+			clientset.PrependReactor("create", "pods", evictionReactor(clientset, tt.budgets))
+
+			events := make(chan DrainEvent, 100)
+			tt.opts.Events = events
+			tt.opts.RetryInterval = 5 * time.Millisecond
+			err := DrainNode(context.Background(), clientset, "node-a", tt.opts)
+			close(events)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DrainNode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			node, _ := clientset.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+			if !node.Spec.Unschedulable {
+				t.Errorf("DrainNode() node not cordoned")
+			}
+
+			pods, _ := clientset.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+			remains := []string{}
+			for _, p := range pods.Items {
+				remains = append(remains, p.Name)
+			}
+			if len(remains) != len(tt.wantRemains) {
+				t.Fatalf("DrainNode() remaining pods = %v, want %v", remains, tt.wantRemains)
+			}
+			for i := range remains {
+				if remains[i] != tt.wantRemains[i] {
+					t.Errorf("DrainNode() remaining pods = %v, want %v", remains, tt.wantRemains)
+				}
+			}
+
+			blocked := 0
+			for e := range events {
+				if e.Phase == DrainBlocked {
+					blocked++
+				}
+			}
+			if tt.wantBlocked > 0 && blocked != tt.wantBlocked {
+				t.Errorf("DrainNode() blocked events = %d, want %d", blocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestDrainNodeEventsNotRead(t *testing.T) {
+	clientset := fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, nodePod("web-0", "node-a", nil))
+	clientset.PrependReactor("create", "pods", evictionReactor(clientset, nil))
+	done := make(chan error)
+	go func() {
+		done <- DrainNode(context.Background(), clientset, "node-a", DrainOptions{
+			Events:        make(chan DrainEvent),
+			Timeout:       50 * time.Millisecond,
+			RetryInterval: 5 * time.Millisecond,
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DrainNode() blocked on an events channel nobody reads")
+	}
+}
+
+func TestCordonNode(t *testing.T) {
+	clientset := fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}})
+	if err := CordonNode(context.Background(), clientset, "node-a"); err != nil {
+		t.Fatalf("CordonNode() error = %v", err)
+	}
+	node, _ := GetNode(clientset, "node-a")
+	if !node.Spec.Unschedulable {
+		t.Errorf("CordonNode() unschedulable = false, want true")
+	}
+	if err := UncordonNode(context.Background(), clientset, "node-a"); err != nil {
+		t.Fatalf("UncordonNode() error = %v", err)
+	}
+	node, _ = GetNode(clientset, "node-a")
+	if node.Spec.Unschedulable {
+		t.Errorf("UncordonNode() unschedulable = true, want false")
+	}
+	if err := CordonNode(context.Background(), clientset, "node-missing"); err == nil {
+		t.Errorf("CordonNode() on missing node error = nil, want error")
+	}
+}
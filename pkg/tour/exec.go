@@ -0,0 +1,259 @@
+package tour
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/streaming/pkg/httpstream"
+)
+
+// ExecOptions selects the container, command and streams of an exec
+type ExecOptions struct {
+	// Container defaults to the only container of the pod.
+	Container string
+	Command   []string
+	Stdin     io.Reader
+	Stdout    io.Writer
+	// Stderr is merged into Stdout by the terminal when TTY is set.
+	Stderr io.Writer
+	TTY    bool
+}
+
+// PodExecutor runs commands in containers over WebSocket, falling back to SPDY
+// for API servers without WebSocket streaming
+type PodExecutor struct {
+	config *rest.Config
+	client rest.Interface
+}
+
+// NewPodExecutor creates an executor for the cluster of config
+func NewPodExecutor(config *rest.Config) (*PodExecutor, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		slog.Error("create kube client failed", "error", err)
+		return nil, err
+	}
+	return &PodExecutor{config: config, client: clientset.CoreV1().RESTClient()}, nil
+}
+
+// Exec runs a command in a pod and returns its exit code, the error is only set
+// when the command could not be run or streamed.
+func (e *PodExecutor) Exec(ctx context.Context, namespace, pod string, opts ExecOptions) (int, error) {
+	req := e.client.Post().Resource("pods").Namespace(namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := newExecutor(e.config, req.URL())
+	if err != nil {
+		slog.Error("create executor failed", "namespace", namespace, "pod", pod, "error", err)
+		return -1, err
+	}
+	streams := remotecommand.StreamOptions{Stdin: opts.Stdin, Stdout: opts.Stdout, Tty: opts.TTY}
+	if opts.Stderr != nil && !opts.TTY {
+		streams.Stderr = opts.Stderr
+	}
+	err = executor.StreamWithContext(ctx, streams)
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		slog.Error("exec failed", "namespace", namespace, "pod", pod, "command", opts.Command, "error", err)
+		return -1, err
+	}
+	return 0, nil
+}
+
+func newExecutor(config *rest.Config, u *url.URL) (remotecommand.Executor, error) {
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+	if err != nil {
+		return nil, err
+	}
+	wsExecutor, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewFallbackExecutor(wsExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+// CopyFrom copies the file or directory src of a container to the local path
+// dst through tar, like kubectl cp. The container image needs a tar binary.
+func (e *PodExecutor) CopyFrom(ctx context.Context, namespace, pod, container, src, dst string) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		stderr := &bytes.Buffer{}
+		code, err := e.Exec(ctx, namespace, pod, ExecOptions{
+			Container: container,
+			Command:   []string{"tar", "cf", "-", "-C", path.Dir(src), path.Base(src)},
+			Stdout:    pw,
+			Stderr:    stderr,
+		})
+		if err == nil && code != 0 {
+			err = fmt.Errorf("tar exited with code %d: %s", code, strings.TrimSpace(stderr.String()))
+		}
+		pw.CloseWithError(err)
+	}()
+
+	if err := untarTo(pr, path.Base(src), dst); err != nil {
+		slog.Error("copy from pod failed", "namespace", namespace, "pod", pod, "src", src, "error", err)
+		return err
+	}
+	// The exec error surfaces once the rest of the stream is read
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		slog.Error("copy from pod failed", "namespace", namespace, "pod", pod, "src", src, "error", err)
+		return err
+	}
+	return nil
+}
+
+// CopyTo copies the local file or directory src into a container as dst
+func (e *PodExecutor) CopyTo(ctx context.Context, namespace, pod, container, src, dst string) error {
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := tarFrom(pw, src, path.Base(dst))
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	stderr := &bytes.Buffer{}
+	code, err := e.Exec(ctx, namespace, pod, ExecOptions{
+		Container: container,
+		Command:   []string{"tar", "xmf", "-", "-C", path.Dir(dst)},
+		Stdin:     pr,
+		Stderr:    stderr,
+	})
+	pr.Close()
+	if werr := <-written; werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		slog.Error("copy to pod failed", "namespace", namespace, "pod", pod, "src", src, "error", werr)
+		return werr
+	}
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("tar exited with code %d: %s", code, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// tarFrom writes src as a tar stream with entries rooted at prefix
+func tarFrom(w io.Writer, src, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			slog.Warn("skip non regular file", "path", p)
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// untarTo extracts the entries under prefix of a tar stream to dst, refusing
+// entries escaping dst and skipping links
+func untarTo(r io.Reader, prefix, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			slog.Warn("skip unexpected tar entry", "name", hdr.Name)
+			continue
+		}
+		target := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		if rel, err := filepath.Rel(dst, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("tar entry %q escapes %s", hdr.Name, dst)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			slog.Warn("skip tar entry", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+	}
+}
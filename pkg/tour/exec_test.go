@@ -0,0 +1,280 @@
+package tour
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+	"k8s.io/streaming/pkg/httpstream"
+	"k8s.io/streaming/pkg/httpstream/spdy"
+)
+
+// fakeContainer runs a few commands against an in memory filesystem
+type fakeContainer struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (c *fakeContainer) run(command []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case len(command) > 0 && command[0] == "echo":
+		io.WriteString(stdout, strings.Join(command[1:], " ")+"\n")
+	case len(command) == 1 && command[0] == "cat":
+		io.Copy(stdout, stdin)
+	case len(command) == 2 && command[0] == "exit":
+		io.WriteString(stderr, "exiting\n")
+		code, _ := strconv.Atoi(command[1])
+		return code
+	case len(command) == 6 && command[0] == "tar" && command[1] == "cf":
+		return c.tarCreate(command[4], command[5], stdout, stderr)
+	case len(command) == 5 && command[0] == "tar" && command[1] == "xmf":
+		return c.tarExtract(command[4], stdin, stderr)
+	default:
+		io.WriteString(stderr, command[0]+": command not found\n")
+		return 127
+	}
+	return 0
+}
+
+func (c *fakeContainer) tarCreate(dir, base string, stdout, stderr io.Writer) int {
+	root := path.Join(dir, base)
+	names := []string{}
+	for name := range c.files {
+		if name == root || strings.HasPrefix(name, root+"/") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		io.WriteString(stderr, "tar: "+base+": No such file or directory\n")
+		return 2
+	}
+	sort.Strings(names)
+	tw := tar.NewWriter(stdout)
+	for _, name := range names {
+		content := c.files[name]
+		tw.WriteHeader(&tar.Header{Name: strings.TrimPrefix(name, dir+"/"), Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write(content)
+	}
+	tw.Close()
+	return 0
+}
+
+func (c *fakeContainer) tarExtract(dir string, stdin io.Reader, stderr io.Writer) int {
+	tr := tar.NewReader(stdin)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			io.WriteString(stderr, "tar: "+err.Error()+"\n")
+			return 2
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			content, _ := io.ReadAll(tr)
+			c.files[path.Join(dir, hdr.Name)] = content
+		}
+	}
+}
+
+// newExecServer serves pod exec over SPDY only, like API servers before WebSocket streaming
+func newExecServer(t *testing.T, c *fakeContainer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "websocket streaming not supported", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasSuffix(req.URL.Path, "/pods/web/exec") {
+			http.NotFound(w, req)
+			return
+		}
+		if _, err := httpstream.Handshake(req, w, []string{remotecommandconsts.StreamProtocolV4Name}); err != nil {
+			return
+		}
+
+		query := req.URL.Query()
+		tty := query.Get("tty") == "true"
+		expected := 1
+		for _, stream := range []string{"stdin", "stdout"} {
+			if query.Get(stream) == "true" {
+				expected++
+			}
+		}
+		if query.Get("stderr") == "true" && !tty {
+			expected++
+		}
+		if tty {
+			expected++
+		}
+
+		streamCh := make(chan httpstream.Stream, expected)
+		conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(s httpstream.Stream, _ <-chan struct{}) error {
+			streamCh <- s
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+
+		streams := map[string]httpstream.Stream{}
+		for len(streams) < expected {
+			select {
+			case s := <-streamCh:
+				streams[s.Headers().Get(corev1.StreamType)] = s
+			case <-time.After(5 * time.Second):
+				t.Errorf("exec server got streams %v, want %d", streams, expected)
+				return
+			}
+		}
+
+		var stdin io.Reader = bytes.NewReader(nil)
+		var stdout, stderr io.Writer = io.Discard, io.Discard
+		if s, ok := streams[corev1.StreamTypeStdin]; ok {
+			stdin = s
+		}
+		if s, ok := streams[corev1.StreamTypeStdout]; ok {
+			stdout = s
+		}
+		if s, ok := streams[corev1.StreamTypeStderr]; ok {
+			stderr = s
+		}
+		code := c.run(query["command"], stdin, stdout, stderr)
+
+		status := metav1.Status{Status: metav1.StatusSuccess}
+		if code != 0 {
+			status = metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  remotecommandconsts.NonZeroExitCodeReason,
+				Message: "command terminated with non-zero exit code",
+				Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{
+					Type:    remotecommandconsts.ExitCodeCauseType,
+					Message: strconv.Itoa(code),
+				}}},
+			}
+		}
+		json.NewEncoder(streams[corev1.StreamTypeError]).Encode(status)
+		for _, s := range streams {
+			s.Close()
+		}
+	}))
+}
+
+func TestPodExecutorExec(t *testing.T) {
+	srv := newExecServer(t, &fakeContainer{files: map[string][]byte{}})
+	defer srv.Close()
+	executor, err := NewPodExecutor(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("NewPodExecutor() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		opts       ExecOptions
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"stdout", ExecOptions{Command: []string{"echo", "hello", "kube"}}, 0, "hello kube\n", ""},
+		{"stdin", ExecOptions{Command: []string{"cat"}, Stdin: strings.NewReader("from stdin")}, 0, "from stdin", ""},
+		{"exit code", ExecOptions{Command: []string{"exit", "3"}}, 3, "", "exiting\n"},
+		{"tty", ExecOptions{Command: []string{"echo", "tty"}, TTY: true}, 0, "tty\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			tt.opts.Stdout, tt.opts.Stderr = stdout, stderr
+			code, err := executor.Exec(context.Background(), "shop", "web", tt.opts)
+			if err != nil {
+				t.Fatalf("Exec() error = %v", err)
+			}
+			if code != tt.wantCode || stdout.String() != tt.wantStdout || stderr.String() != tt.wantStderr {
+				t.Errorf("Exec() = %d, stdout %q, stderr %q, want %d, %q, %q",
+					code, stdout.String(), stderr.String(), tt.wantCode, tt.wantStdout, tt.wantStderr)
+			}
+		})
+	}
+
+	if _, err := executor.Exec(context.Background(), "shop", "missing", ExecOptions{Command: []string{"echo"}, Stdout: io.Discard}); err == nil {
+		t.Errorf("Exec() unknown pod error = nil")
+	}
+}
+
+func TestPodExecutorCopy(t *testing.T) {
+	container := &fakeContainer{files: map[string][]byte{
+		"/etc/app/config.yaml":   []byte("mode: prod\n"),
+		"/etc/app/certs/tls.crt": []byte("cert"),
+		"/etc/other":             []byte("unrelated"),
+	}}
+	srv := newExecServer(t, container)
+	defer srv.Close()
+	executor, err := NewPodExecutor(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("NewPodExecutor() error = %v", err)
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	local := filepath.Join(dir, "app")
+	if err := executor.CopyFrom(ctx, "shop", "web", "", "/etc/app", local); err != nil {
+		t.Fatalf("CopyFrom() error = %v", err)
+	}
+	for name, want := range map[string]string{"config.yaml": "mode: prod\n", "certs/tls.crt": "cert"} {
+		got, err := os.ReadFile(filepath.Join(local, name))
+		if err != nil || string(got) != want {
+			t.Errorf("CopyFrom() %s = %q, error = %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other")); err == nil {
+		t.Errorf("CopyFrom() copied a file outside the source")
+	}
+	if err := executor.CopyFrom(ctx, "shop", "web", "", "/etc/missing", filepath.Join(dir, "missing")); err == nil || !strings.Contains(err.Error(), "code 2") {
+		t.Errorf("CopyFrom() missing source error = %v, want tar exit code 2", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(local, "config.yaml"), []byte("mode: dev\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := executor.CopyTo(ctx, "shop", "web", "", local, "/srv/app"); err != nil {
+		t.Fatalf("CopyTo() error = %v", err)
+	}
+	container.mu.Lock()
+	defer container.mu.Unlock()
+	if got := string(container.files["/srv/app/config.yaml"]); got != "mode: dev\n" {
+		t.Errorf("CopyTo() config.yaml = %q, want mode: dev", got)
+	}
+	if got := string(container.files["/srv/app/certs/tls.crt"]); got != "cert" {
+		t.Errorf("CopyTo() certs/tls.crt = %q, want cert", got)
+	}
+}
+
+func TestUntarToRejectsEscapes(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "app/../../evil", Mode: 0o644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+
+	if err := untarTo(buf, "app", t.TempDir()); err == nil {
+		t.Errorf("untarTo() error = nil, want escape rejected")
+	}
+}
@@ -0,0 +1,239 @@
+package tour
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const defaultRegistry = "docker.io"
+
+// ImageRef is a container image reference split into its parts
+type ImageRef struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+// ParseImageRef splits an image like registry:5000/team/app:v1@sha256:... into
+// its parts, filling in the implicit docker.io registry and library namespace.
+func ParseImageRef(image string) ImageRef {
+	ref := ImageRef{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+		return ref
+	}
+	ref.Registry, ref.Repository = defaultRegistry, name
+	if !found {
+		ref.Repository = "library/" + name
+	}
+	return ref
+}
+
+// Name is the repository with its tag and digest, leaving out the registry
+func (r ImageRef) Name() string {
+	name := r.Repository
+	if r.Tag != "" {
+		name += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		name += "@" + r.Digest
+	}
+	return name
+}
+
+// ImageInfo aggregates where an image is used across the cluster
+type ImageInfo struct {
+	Image string `json:"image"`
+	ImageRef
+	// Pinned reports the image is referenced by digest.
+	Pinned bool `json:"pinned"`
+	// Latest reports the image uses the mutable latest tag, explicitly or implicitly.
+	Latest bool `json:"latest"`
+	// Untagged reports the image has neither a tag nor a digest.
+	Untagged bool `json:"untagged"`
+	// PullAlways reports any container uses imagePullPolicy Always.
+	PullAlways bool     `json:"pullAlways"`
+	Namespaces []string `json:"namespaces"`
+	Workloads  []string `json:"workloads"`
+}
+
+type imageInventory map[string]*ImageInfo
+
+func (inv imageInventory) add(namespace, workload string, containers []corev1.Container) {
+	for _, c := range containers {
+		info, ok := inv[c.Image]
+		if !ok {
+			ref := ParseImageRef(c.Image)
+			info = &ImageInfo{
+				Image:    c.Image,
+				ImageRef: ref,
+				Pinned:   ref.Digest != "",
+				Latest:   ref.Tag == "latest" || (ref.Tag == "" && ref.Digest == ""),
+				Untagged: ref.Tag == "" && ref.Digest == "",
+			}
+			inv[c.Image] = info
+		}
+		info.PullAlways = info.PullAlways || c.ImagePullPolicy == corev1.PullAlways
+		if !slices.Contains(info.Namespaces, namespace) {
+			info.Namespaces = append(info.Namespaces, namespace)
+		}
+		if !slices.Contains(info.Workloads, workload) {
+			info.Workloads = append(info.Workloads, workload)
+		}
+	}
+}
+
+func (inv imageInventory) addPodSpec(namespace, workload string, spec *corev1.PodSpec) {
+	inv.add(namespace, workload, spec.InitContainers)
+	inv.add(namespace, workload, spec.Containers)
+}
+
+func (inv imageInventory) sorted() []ImageInfo {
+	items := make([]ImageInfo, 0, len(inv))
+	for _, info := range inv {
+		sort.Strings(info.Namespaces)
+		sort.Strings(info.Workloads)
+		items = append(items, *info)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Image < items[j].Image })
+	return items
+}
+
+// BuildImageInventory walks pods and workload templates in namespace, an empty
+// namespace means the whole cluster.
+func BuildImageInventory(clientset kubernetes.Interface, namespace string) ([]ImageInfo, error) {
+	ctx, opts := context.TODO(), metav1.ListOptions{}
+	inv := imageInventory{}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list pods failed", "error", err)
+		return nil, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ref := PodWorkload(pod, nil)
+		inv.addPodSpec(pod.Namespace, ref.Kind+"/"+ref.Name, &pod.Spec)
+	}
+
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list deployments failed", "error", err)
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		inv.addPodSpec(d.Namespace, "Deployment/"+d.Name, &d.Spec.Template.Spec)
+	}
+
+	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list statefulsets failed", "error", err)
+		return nil, err
+	}
+	for _, s := range statefulSets.Items {
+		inv.addPodSpec(s.Namespace, "StatefulSet/"+s.Name, &s.Spec.Template.Spec)
+	}
+
+	daemonSets, err := clientset.AppsV1().DaemonSets(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list daemonsets failed", "error", err)
+		return nil, err
+	}
+	for _, d := range daemonSets.Items {
+		inv.addPodSpec(d.Namespace, "DaemonSet/"+d.Name, &d.Spec.Template.Spec)
+	}
+
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list jobs failed", "error", err)
+		return nil, err
+	}
+	for _, j := range jobs.Items {
+		if ref := metav1.GetControllerOf(&j); ref != nil && ref.Kind == "CronJob" {
+			continue
+		}
+		inv.addPodSpec(j.Namespace, "Job/"+j.Name, &j.Spec.Template.Spec)
+	}
+
+	cronJobs, err := clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+	if err != nil {
+		slog.Error("list cronjobs failed", "error", err)
+		return nil, err
+	}
+	for _, c := range cronJobs.Items {
+		inv.addPodSpec(c.Namespace, "CronJob/"+c.Name, &c.Spec.JobTemplate.Spec.Template.Spec)
+	}
+	return inv.sorted(), nil
+}
+
+// WriteImageInventory renders the inventory as table, json or csv
+func WriteImageInventory(w io.Writer, format string, items []ImageInfo) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"image", "registry", "repository", "tag", "digest", "pinned", "latest", "untagged", "pullAlways", "namespaces", "workloads"})
+		for _, i := range items {
+			_ = cw.Write([]string{
+				i.Image, i.Registry, i.Repository, i.Tag, i.Digest,
+				strconv.FormatBool(i.Pinned), strconv.FormatBool(i.Latest),
+				strconv.FormatBool(i.Untagged), strconv.FormatBool(i.PullAlways),
+				strings.Join(i.Namespaces, ";"), strings.Join(i.Workloads, ";"),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMAGE\tREGISTRY\tPINNED\tPULL-ALWAYS\tFLAGS\tNAMESPACES\tWORKLOADS")
+		for _, i := range items {
+			flags := []string{}
+			if i.Latest {
+				flags = append(flags, "latest")
+			}
+			if i.Untagged {
+				flags = append(flags, "untagged")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\t%s\t%s\n",
+				i.ImageRef.Name(), i.Registry, i.Pinned, i.PullAlways, orDash(strings.Join(flags, ",")),
+				strings.Join(i.Namespaces, ","), strings.Join(i.Workloads, ","))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
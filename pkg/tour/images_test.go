@@ -0,0 +1,151 @@
+package tour
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  ImageRef
+	}{
+		{"nginx", ImageRef{Registry: "docker.io", Repository: "library/nginx"}},
+		{"memcached:1.6.26-alpine3.19", ImageRef{Registry: "docker.io", Repository: "library/memcached", Tag: "1.6.26-alpine3.19"}},
+		{"urans/guestbook:v0.1", ImageRef{Registry: "docker.io", Repository: "urans/guestbook", Tag: "v0.1"}},
+		{"registry.k8s.io/pause:3.9", ImageRef{Registry: "registry.k8s.io", Repository: "pause", Tag: "3.9"}},
+		{"localhost:5000/team/app", ImageRef{Registry: "localhost:5000", Repository: "team/app"}},
+		{"gcr.io/app@sha256:abc", ImageRef{Registry: "gcr.io", Repository: "app", Digest: "sha256:abc"}},
+		{"quay.io/app:v2@sha256:abc", ImageRef{Registry: "quay.io", Repository: "app", Tag: "v2", Digest: "sha256:abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := ParseImageRef(tt.image); got != tt.want {
+				t.Errorf("ParseImageRef() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildImageInventory(t *testing.T) {
+	controller := true
+	podSpec := func(image string, policy corev1.PullPolicy) corev1.PodSpec {
+		return corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image, ImagePullPolicy: policy}}}
+	}
+	clientset := fake.NewClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("nginx:1.27", corev1.PullIfNotPresent)}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "shop", Name: "web-7d9f-x2x", Labels: map[string]string{"pod-template-hash": "7d9f"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-7d9f", Controller: &controller}},
+			},
+			Spec: podSpec("nginx:1.27", corev1.PullIfNotPresent),
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "debug", Name: "shell"},
+			Spec:       podSpec("busybox", corev1.PullAlways),
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "backup"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec("gcr.io/ops/backup@sha256:0a1b", corev1.PullIfNotPresent)},
+			}}},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "ops", Name: "backup-28000",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "backup", Controller: &controller}},
+			},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("gcr.io/ops/backup@sha256:0a1b", corev1.PullIfNotPresent)}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cache"},
+			Spec:       appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("memcached:latest", corev1.PullAlways)}},
+		},
+	)
+
+	got, err := BuildImageInventory(clientset, "")
+	if err != nil {
+		t.Fatalf("BuildImageInventory() error = %v", err)
+	}
+	want := []struct {
+		image      string
+		workloads  string
+		latest     bool
+		untagged   bool
+		pinned     bool
+		pullAlways bool
+	}{
+		{"busybox", "Pod/shell", true, true, false, true},
+		{"gcr.io/ops/backup@sha256:0a1b", "CronJob/backup", false, false, true, false},
+		{"memcached:latest", "StatefulSet/cache", true, false, false, true},
+		{"nginx:1.27", "Deployment/web", false, false, false, false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("BuildImageInventory() got %d images, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Image != w.image || strings.Join(g.Workloads, ",") != w.workloads ||
+			g.Latest != w.latest || g.Untagged != w.untagged || g.Pinned != w.pinned || g.PullAlways != w.pullAlways {
+			t.Errorf("BuildImageInventory() item %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestWriteImageInventory(t *testing.T) {
+	items := []ImageInfo{{
+		Image:      "nginx",
+		ImageRef:   ParseImageRef("nginx"),
+		Latest:     true,
+		Untagged:   true,
+		Namespaces: []string{"default"},
+		Workloads:  []string{"Deployment/web"},
+	}, {
+		Image:      "ghcr.io/team/app:v2@sha256:ab12",
+		ImageRef:   ParseImageRef("ghcr.io/team/app:v2@sha256:ab12"),
+		Pinned:     true,
+		Namespaces: []string{"default"},
+		Workloads:  []string{"Deployment/app"},
+	}}
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{"table", "library/nginx            docker.io", false},
+		{"table", "team/app:v2@sha256:ab12", false},
+		{"csv", "nginx,docker.io,library/nginx,,,false,true,true,false,default,Deployment/web", false},
+		{"json", `"repository": "library/nginx"`, false},
+		{"xml", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := WriteImageInventory(buf, tt.format, items)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteImageInventory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("WriteImageInventory() = %s, want to contain %s", buf.String(), tt.want)
+			}
+			if tt.format == "json" {
+				decoded := []ImageInfo{}
+				if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != len(items) {
+					t.Errorf("WriteImageInventory() json = %v, error = %v", decoded, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package tour
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LogSource opens the log stream of a single container
+type LogSource interface {
+	OpenLog(ctx context.Context, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+}
+
+type kubeLogSource struct {
+	clientset kubernetes.Interface
+}
+
+func (s kubeLogSource) OpenLog(ctx context.Context, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return s.clientset.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream(ctx)
+}
+
+// NewLogSource creates a LogSource reading logs through the kube apiserver
+func NewLogSource(clientset kubernetes.Interface) LogSource {
+	return kubeLogSource{clientset: clientset}
+}
+
+// LogOptions controls which logs are read and how much of them
+type LogOptions struct {
+	// Since only returns logs newer than this duration, zero means all logs.
+	Since time.Duration
+	// Previous reads the logs of the previous terminated container instance.
+	Previous bool
+	// Follow keeps streaming and picks up pods created after the start.
+	Follow bool
+	// Timestamps prefixes every line with the RFC3339 timestamp from kubelet.
+	Timestamps bool
+	// MaxBytes caps the container log bytes written across all containers, the
+	// line prefixes are not counted. Zero means no limit.
+	MaxBytes int64
+}
+
+func (o LogOptions) podLogOptions(container string) *corev1.PodLogOptions {
+	plo := &corev1.PodLogOptions{
+		Container:  container,
+		Follow:     o.Follow,
+		Previous:   o.Previous,
+		Timestamps: o.Timestamps,
+	}
+	if o.Since > 0 {
+		seconds := int64(o.Since.Seconds())
+		plo.SinceSeconds = &seconds
+	}
+	if o.MaxBytes > 0 {
+		plo.LimitBytes = &o.MaxBytes
+	}
+	return plo
+}
+
+type logStream struct {
+	active   bool
+	restarts int32
+}
+
+// LogAggregator multiplexes the logs of all containers of label-selected pods
+// into one writer, prefixing each line with namespace/pod/container.
+type LogAggregator struct {
+	clientset kubernetes.Interface
+	source    LogSource
+	opts      LogOptions
+
+	mu      sync.Mutex
+	out     io.Writer
+	written int64
+	streams map[string]*logStream
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+}
+
+// NewLogAggregator creates a LogAggregator, a nil source reads from the apiserver
+func NewLogAggregator(clientset kubernetes.Interface, source LogSource, out io.Writer, opts LogOptions) *LogAggregator {
+	if source == nil {
+		source = NewLogSource(clientset)
+	}
+	return &LogAggregator{
+		clientset: clientset,
+		source:    source,
+		opts:      opts,
+		out:       out,
+		streams:   make(map[string]*logStream),
+	}
+}
+
+// Run streams logs of the pods matching labels in namespace. Without Follow
+// it returns once every container log has been read, otherwise it watches
+// for new pods until ctx is cancelled or MaxBytes is reached.
+func (la *LogAggregator) Run(ctx context.Context, namespace string, labels map[string]string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	la.cancel = cancel
+
+	selector := buildLabelSelector(labels)
+	if !la.opts.Follow {
+		pods, err := la.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			slog.Error("list pods failed", "namespace", namespace, "selector", selector, "error", err)
+			return err
+		}
+		for i := range pods.Items {
+			la.startPod(ctx, &pods.Items[i])
+		}
+		la.wg.Wait()
+		return nil
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(la.clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}),
+	)
+	informer := factory.Core().V1().Pods().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				la.startPod(ctx, pod)
+			}
+		},
+		UpdateFunc: func(_, newObj any) {
+			if pod, ok := newObj.(*corev1.Pod); ok {
+				la.startPod(ctx, pod)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				la.forgetPod(pod)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	<-ctx.Done()
+	factory.Shutdown()
+	la.wg.Wait()
+	return nil
+}
+
+// startPod starts one stream per container which has logs to read and is not streaming yet
+func (la *LogAggregator) startPod(ctx context.Context, pod *corev1.Pod) {
+	for _, c := range pod.Spec.Containers {
+		status, ok := containerStatus(pod, c.Name)
+		if !ok || !la.hasLogs(status) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, c.Name)
+		la.mu.Lock()
+		s, exists := la.streams[key]
+		if exists && (s.active || s.restarts == status.RestartCount) {
+			la.mu.Unlock()
+			continue
+		}
+		s = &logStream{active: true, restarts: status.RestartCount}
+		la.streams[key] = s
+		la.mu.Unlock()
+
+		la.wg.Add(1)
+		go func(namespace, pod, container string) {
+			defer la.wg.Done()
+			la.follow(ctx, s, key, namespace, pod, container)
+		}(pod.Namespace, pod.Name, c.Name)
+	}
+}
+
+// forgetPod drops the streams of a deleted pod, a stream still reading ends
+// with the log of its container
+func (la *LogAggregator) forgetPod(pod *corev1.Pod) {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	for _, c := range pod.Spec.Containers {
+		delete(la.streams, fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, c.Name))
+	}
+}
+
+func (la *LogAggregator) hasLogs(status corev1.ContainerStatus) bool {
+	if la.opts.Previous {
+		return status.LastTerminationState.Terminated != nil
+	}
+	return status.State.Running != nil || status.State.Terminated != nil
+}
+
+func containerStatus(pod *corev1.Pod, name string) (corev1.ContainerStatus, bool) {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return corev1.ContainerStatus{}, false
+}
+
+func (la *LogAggregator) follow(ctx context.Context, stream *logStream, key, namespace, pod, container string) {
+	defer func() {
+		la.mu.Lock()
+		stream.active = false
+		la.mu.Unlock()
+	}()
+
+	rc, err := la.source.OpenLog(ctx, namespace, pod, la.opts.podLogOptions(container))
+	if err != nil {
+		slog.Error("open container log failed", "container", key, "error", err)
+		return
+	}
+	defer rc.Close()
+
+	prefix := "[" + key + "] "
+	reader := bufio.NewReader(rc)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if !la.write(prefix, line) {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				slog.Error("read container log failed", "container", key, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// write emits a prefixed line and reports false once MaxBytes is exhausted
+func (la *LogAggregator) write(prefix string, line []byte) bool {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+
+	if la.opts.MaxBytes > 0 {
+		remaining := la.opts.MaxBytes - la.written
+		if remaining <= 0 {
+			la.cancel()
+			return false
+		}
+		if int64(len(line)) > remaining {
+			line = line[:remaining]
+		}
+	}
+	if _, err := io.WriteString(la.out, prefix); err != nil {
+		slog.Error("write log line failed", "error", err)
+		return false
+	}
+	n, err := la.out.Write(line)
+	la.written += int64(n)
+	if err != nil {
+		slog.Error("write log line failed", "error", err)
+		return false
+	}
+	return true
+}
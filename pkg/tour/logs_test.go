@@ -0,0 +1,207 @@
+package tour
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeLogSource struct {
+	mu   sync.Mutex
+	logs map[string]string
+	opts []*corev1.PodLogOptions
+}
+
+func (s *fakeLogSource) OpenLog(_ context.Context, namespace, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts = append(s.opts, opts)
+	content, ok := s.logs[namespace+"/"+pod+"/"+opts.Container]
+	if !ok {
+		return nil, fmt.Errorf("no logs for %s/%s/%s", namespace, pod, opts.Container)
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func runningPod(namespace, name string, labels map[string]string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  c,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+			},
+		})
+	}
+	return pod
+}
+
+func sortedLines(s string) []string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestLogAggregatorRun(t *testing.T) {
+	app := map[string]string{"app": "memcached"}
+	source := map[string]string{
+		"default/mc-0/memcached": "started\nlistening on 11211\n",
+		"default/mc-0/exporter":  "serving metrics",
+		"default/mc-1/memcached": "started\n",
+		"default/other/main":     "not selected\n",
+	}
+	tests := []struct {
+		name string
+		opts LogOptions
+		want []string
+	}{
+		{"all", LogOptions{}, []string{
+			"[default/mc-0/exporter] serving metrics",
+			"[default/mc-0/memcached] listening on 11211",
+			"[default/mc-0/memcached] started",
+			"[default/mc-1/memcached] started",
+		}},
+		{"previous", LogOptions{Previous: true, Since: time.Minute}, []string{
+			"[default/mc-0/exporter] serving metrics",
+			"[default/mc-0/memcached] listening on 11211",
+			"[default/mc-0/memcached] started",
+			"[default/mc-1/memcached] started",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset(
+				runningPod("default", "mc-0", app, "memcached", "exporter"),
+				runningPod("default", "mc-1", app, "memcached"),
+				runningPod("default", "other", nil, "main"),
+			)
+			src := &fakeLogSource{logs: source}
+			out := &syncBuffer{}
+			err := NewLogAggregator(clientset, src, out, tt.opts).Run(context.Background(), "default", app)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got := sortedLines(out.String())
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Run() got = %q, want %q", got, tt.want)
+			}
+			for _, opts := range src.opts {
+				if opts.Previous != tt.opts.Previous {
+					t.Errorf("Run() previous = %v, want %v", opts.Previous, tt.opts.Previous)
+				}
+				if tt.opts.Since > 0 && (opts.SinceSeconds == nil || *opts.SinceSeconds != int64(tt.opts.Since.Seconds())) {
+					t.Errorf("Run() sinceSeconds = %v, want %v", opts.SinceSeconds, tt.opts.Since)
+				}
+			}
+		})
+	}
+}
+
+func TestLogAggregatorMaxBytes(t *testing.T) {
+	clientset := fake.NewClientset(runningPod("default", "mc-0", nil, "memcached"))
+	src := &fakeLogSource{logs: map[string]string{
+		"default/mc-0/memcached": "0123456789\nabcdefghij\n",
+	}}
+	out := &syncBuffer{}
+	err := NewLogAggregator(clientset, src, out, LogOptions{MaxBytes: 15}).Run(context.Background(), "default", nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := "[default/mc-0/memcached] 0123456789\n[default/mc-0/memcached] abcd"
+	if got := out.String(); got != want {
+		t.Errorf("Run() got = %q, want %q", got, want)
+	}
+}
+
+func TestLogAggregatorFollowNewPods(t *testing.T) {
+	app := map[string]string{"app": "memcached"}
+	clientset := fake.NewClientset(runningPod("default", "mc-0", app, "memcached"))
+	src := &fakeLogSource{logs: map[string]string{
+		"default/mc-0/memcached": "first\n",
+		"default/mc-1/memcached": "second\n",
+	}}
+	out := &syncBuffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	la := NewLogAggregator(clientset, src, out, LogOptions{Follow: true})
+	go func() {
+		done <- la.Run(ctx, "default", app)
+	}()
+
+	waitFor := func(want string, count int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for strings.Count(out.String(), want) < count {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %q, got %q", want, out.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("[default/mc-0/memcached] first", 1)
+
+	_, err := clientset.CoreV1().Pods("default").Create(ctx, runningPod("default", "mc-1", app, "memcached"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create pod error: %v", err)
+	}
+	waitFor("[default/mc-1/memcached] second", 1)
+
+	// A deleted pod is forgotten, one recreated with its name is streamed again
+	if err := clientset.CoreV1().Pods("default").Delete(ctx, "mc-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete pod error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		la.mu.Lock()
+		_, tracked := la.streams["default/mc-0/memcached"]
+		la.mu.Unlock()
+		if !tracked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the deleted pod to be forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := clientset.CoreV1().Pods("default").Create(ctx, runningPod("default", "mc-0", app, "memcached"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod error: %v", err)
+	}
+	waitFor("[default/mc-0/memcached] first", 2)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
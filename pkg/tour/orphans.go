@@ -0,0 +1,402 @@
+package tour
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/urans/kubemaze/pkg/recipe"
+)
+
+// Orphan is an object nothing in the cluster refers to anymore
+type Orphan struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Reason    string        `json:"reason"`
+	Age       time.Duration `json:"age"`
+}
+
+func (o Orphan) key() string {
+	return refKey(o.Kind, o.Namespace, o.Name)
+}
+
+func refKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// refGraph maps a referenced object key to the keys of the objects referring to it
+type refGraph map[string][]string
+
+func (g refGraph) add(from, kind, namespace, name string) {
+	if name == "" {
+		return
+	}
+	to := refKey(kind, namespace, name)
+	g[to] = append(g[to], from)
+}
+
+func (g refGraph) referenced(kind, namespace, name string) bool {
+	return len(g[refKey(kind, namespace, name)]) > 0
+}
+
+// addPodSpec records the ConfigMaps, Secrets and PVCs used by a pod or pod template
+func (g refGraph) addPodSpec(from, namespace string, spec *corev1.PodSpec) {
+	for _, s := range spec.ImagePullSecrets {
+		g.add(from, "Secret", namespace, s.Name)
+	}
+	for _, v := range spec.Volumes {
+		switch {
+		case v.ConfigMap != nil:
+			g.add(from, "ConfigMap", namespace, v.ConfigMap.Name)
+		case v.Secret != nil:
+			g.add(from, "Secret", namespace, v.Secret.SecretName)
+		case v.PersistentVolumeClaim != nil:
+			g.add(from, "PersistentVolumeClaim", namespace, v.PersistentVolumeClaim.ClaimName)
+		case v.Projected != nil:
+			for _, src := range v.Projected.Sources {
+				if src.ConfigMap != nil {
+					g.add(from, "ConfigMap", namespace, src.ConfigMap.Name)
+				}
+				if src.Secret != nil {
+					g.add(from, "Secret", namespace, src.Secret.Name)
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				g.add(from, "ConfigMap", namespace, e.ConfigMapRef.Name)
+			}
+			if e.SecretRef != nil {
+				g.add(from, "Secret", namespace, e.SecretRef.Name)
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if ref := e.ValueFrom.ConfigMapKeyRef; ref != nil {
+				g.add(from, "ConfigMap", namespace, ref.Name)
+			}
+			if ref := e.ValueFrom.SecretKeyRef; ref != nil {
+				g.add(from, "Secret", namespace, ref.Name)
+			}
+		}
+	}
+}
+
+// orphanSnapshot holds the listed objects the analysis runs on
+type orphanSnapshot struct {
+	pods            []corev1.Pod
+	configMaps      []corev1.ConfigMap
+	secrets         []corev1.Secret
+	services        []corev1.Service
+	claims          []corev1.PersistentVolumeClaim
+	serviceAccounts []corev1.ServiceAccount
+	deployments     []appsv1.Deployment
+	statefulSets    []appsv1.StatefulSet
+	daemonSets      []appsv1.DaemonSet
+	replicaSets     []appsv1.ReplicaSet
+	jobs            []batchv1.Job
+	cronJobs        []batchv1.CronJob
+	ingresses       []networkingv1.Ingress
+}
+
+// FindOrphans reports unreferenced ConfigMaps, Secrets and PVCs, Services selecting
+// no pods and scaled down ReplicaSets in namespace, empty means all namespaces.
+func FindOrphans(clientset kubernetes.Interface, namespace string) ([]Orphan, error) {
+	snapshot, err := listOrphanSnapshot(clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return findOrphans(snapshot, time.Now()), nil
+}
+
+func listOrphanSnapshot(clientset kubernetes.Interface, namespace string) (*orphanSnapshot, error) {
+	ctx, opts := context.TODO(), metav1.ListOptions{}
+	s := &orphanSnapshot{}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+	s.pods = pods.Items
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list configmaps: %w", err)
+	}
+	s.configMaps = configMaps.Items
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	s.secrets = secrets.Items
+	services, err := clientset.CoreV1().Services(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	s.services = services.Items
+	claims, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list persistentvolumeclaims: %w", err)
+	}
+	s.claims = claims.Items
+	serviceAccounts, err := clientset.CoreV1().ServiceAccounts(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list serviceaccounts: %w", err)
+	}
+	s.serviceAccounts = serviceAccounts.Items
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list deployments: %w", err)
+	}
+	s.deployments = deployments.Items
+	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list statefulsets: %w", err)
+	}
+	s.statefulSets = statefulSets.Items
+	daemonSets, err := clientset.AppsV1().DaemonSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list daemonsets: %w", err)
+	}
+	s.daemonSets = daemonSets.Items
+	replicaSets, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list replicasets: %w", err)
+	}
+	s.replicaSets = replicaSets.Items
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	s.jobs = jobs.Items
+	cronJobs, err := clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list cronjobs: %w", err)
+	}
+	s.cronJobs = cronJobs.Items
+	ingresses, err := clientset.NetworkingV1().Ingresses(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list ingresses: %w", err)
+	}
+	s.ingresses = ingresses.Items
+	return s, nil
+}
+
+func buildRefGraph(s *orphanSnapshot) refGraph {
+	g := refGraph{}
+	for i := range s.pods {
+		p := &s.pods[i]
+		g.addPodSpec(refKey("Pod", p.Namespace, p.Name), p.Namespace, &p.Spec)
+	}
+	// Templates keep references alive for workloads scaled to zero
+	for i := range s.deployments {
+		d := &s.deployments[i]
+		g.addPodSpec(refKey("Deployment", d.Namespace, d.Name), d.Namespace, &d.Spec.Template.Spec)
+	}
+	for i := range s.statefulSets {
+		d := &s.statefulSets[i]
+		from := refKey("StatefulSet", d.Namespace, d.Name)
+		g.addPodSpec(from, d.Namespace, &d.Spec.Template.Spec)
+		for _, tmpl := range d.Spec.VolumeClaimTemplates {
+			for n := range replicasOrDefault(d.Spec.Replicas) {
+				g.add(from, "PersistentVolumeClaim", d.Namespace, claimName(tmpl.Name, d.Name, int(n)))
+			}
+		}
+	}
+	for i := range s.daemonSets {
+		d := &s.daemonSets[i]
+		g.addPodSpec(refKey("DaemonSet", d.Namespace, d.Name), d.Namespace, &d.Spec.Template.Spec)
+	}
+	for i := range s.jobs {
+		j := &s.jobs[i]
+		g.addPodSpec(refKey("Job", j.Namespace, j.Name), j.Namespace, &j.Spec.Template.Spec)
+	}
+	for i := range s.cronJobs {
+		c := &s.cronJobs[i]
+		g.addPodSpec(refKey("CronJob", c.Namespace, c.Name), c.Namespace, &c.Spec.JobTemplate.Spec.Template.Spec)
+	}
+	for _, ing := range s.ingresses {
+		from := refKey("Ingress", ing.Namespace, ing.Name)
+		for _, tls := range ing.Spec.TLS {
+			g.add(from, "Secret", ing.Namespace, tls.SecretName)
+		}
+	}
+	for _, sa := range s.serviceAccounts {
+		from := refKey("ServiceAccount", sa.Namespace, sa.Name)
+		for _, ref := range sa.Secrets {
+			g.add(from, "Secret", sa.Namespace, ref.Name)
+		}
+		for _, ref := range sa.ImagePullSecrets {
+			g.add(from, "Secret", sa.Namespace, ref.Name)
+		}
+	}
+	return g
+}
+
+// claimName is the name of the PVC a StatefulSet creates from a claim template
+// for the pod of the given ordinal
+func claimName(template, statefulSet string, ordinal int) string {
+	return template + "-" + statefulSet + "-" + strconv.Itoa(ordinal)
+}
+
+// claimedByStatefulSet reports whether the PVC was created from a claim template
+// of a StatefulSet, at any ordinal so claims kept after scaling down are retained
+func claimedByStatefulSet(pvc *corev1.PersistentVolumeClaim, statefulSets []appsv1.StatefulSet) bool {
+	for _, sts := range statefulSets {
+		if sts.Namespace != pvc.Namespace {
+			continue
+		}
+		for _, tmpl := range sts.Spec.VolumeClaimTemplates {
+			ordinal, ok := strings.CutPrefix(pvc.Name, tmpl.Name+"-"+sts.Name+"-")
+			if _, err := strconv.Atoi(ordinal); ok && err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// controllerExists reports whether the controller owning a ReplicaSet is still
+// there, owners of kinds not listed are assumed to exist
+func controllerExists(rs *appsv1.ReplicaSet, deployments []appsv1.Deployment) bool {
+	ref := metav1.GetControllerOf(rs)
+	if ref == nil {
+		return false
+	}
+	if ref.Kind != "Deployment" {
+		return true
+	}
+	for _, d := range deployments {
+		if d.Namespace == rs.Namespace && d.Name == ref.Name && (ref.UID == "" || d.UID == ref.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+func findOrphans(s *orphanSnapshot, now time.Time) []Orphan {
+	g := buildRefGraph(s)
+	orphans := []Orphan{}
+	report := func(kind string, meta metav1.ObjectMeta, reason string) {
+		orphans = append(orphans, Orphan{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    reason,
+			Age:       now.Sub(meta.CreationTimestamp.Time).Truncate(time.Second),
+		})
+	}
+
+	for _, cm := range s.configMaps {
+		if cm.Name == "kube-root-ca.crt" || len(cm.OwnerReferences) > 0 {
+			continue
+		}
+		if !g.referenced("ConfigMap", cm.Namespace, cm.Name) {
+			report("ConfigMap", cm.ObjectMeta, "not referenced by any pod or workload template")
+		}
+	}
+	for _, secret := range s.secrets {
+		switch secret.Type {
+		case corev1.SecretTypeServiceAccountToken, "helm.sh/release.v1":
+			continue
+		}
+		if len(secret.OwnerReferences) > 0 {
+			continue
+		}
+		if !g.referenced("Secret", secret.Namespace, secret.Name) {
+			report("Secret", secret.ObjectMeta, "not referenced by any pod, workload template, service account or ingress")
+		}
+	}
+	for _, pvc := range s.claims {
+		if len(pvc.OwnerReferences) > 0 || claimedByStatefulSet(&pvc, s.statefulSets) {
+			continue
+		}
+		if !g.referenced("PersistentVolumeClaim", pvc.Namespace, pvc.Name) {
+			report("PersistentVolumeClaim", pvc.ObjectMeta, "not mounted by any pod, workload template or claim template")
+		}
+	}
+	for _, svc := range s.services {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if !selectsAnyPod(svc.Namespace, labels.SelectorFromSet(svc.Spec.Selector), s.pods) {
+			report("Service", svc.ObjectMeta, fmt.Sprintf("selector %s matches no pods", labels.FormatLabels(svc.Spec.Selector)))
+		}
+	}
+	for _, rs := range s.replicaSets {
+		// Scaled down ReplicaSets of a Deployment are its revision history
+		if controllerExists(&rs, s.deployments) {
+			continue
+		}
+		if replicasOrDefault(rs.Spec.Replicas) == 0 && rs.Status.Replicas == 0 {
+			report("ReplicaSet", rs.ObjectMeta, "scaled to zero replicas")
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].key() < orphans[j].key() })
+	return orphans
+}
+
+func selectsAnyPod(namespace string, selector labels.Selector, pods []corev1.Pod) bool {
+	for _, p := range pods {
+		if p.Namespace == namespace && selector.Matches(labels.Set(p.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteOrphans deletes the given orphans through recipe.SlowStartBatch starting
+// with batchSize concurrent deletions, it returns the number deleted.
+func DeleteOrphans(clientset kubernetes.Interface, orphans []Orphan, batchSize int) (int, error) {
+	next := atomic.Int64{}
+	return recipe.SlowStartBatch(len(orphans), max(batchSize, 1), func() error {
+		o := orphans[next.Add(1)-1]
+		err := deleteOrphan(clientset, o)
+		if kerrs.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			slog.Error("delete orphan failed", "kind", o.Kind, "namespace", o.Namespace, "name", o.Name, "error", err)
+			return err
+		}
+		slog.Info("orphan deleted", "kind", o.Kind, "namespace", o.Namespace, "name", o.Name)
+		return nil
+	})
+}
+
+func deleteOrphan(clientset kubernetes.Interface, o Orphan) error {
+	ctx, opts := context.TODO(), metav1.DeleteOptions{}
+	switch o.Kind {
+	case "ConfigMap":
+		return clientset.CoreV1().ConfigMaps(o.Namespace).Delete(ctx, o.Name, opts)
+	case "Secret":
+		return clientset.CoreV1().Secrets(o.Namespace).Delete(ctx, o.Name, opts)
+	case "PersistentVolumeClaim":
+		return clientset.CoreV1().PersistentVolumeClaims(o.Namespace).Delete(ctx, o.Name, opts)
+	case "Service":
+		return clientset.CoreV1().Services(o.Namespace).Delete(ctx, o.Name, opts)
+	case "ReplicaSet":
+		return clientset.AppsV1().ReplicaSets(o.Namespace).Delete(ctx, o.Name, opts)
+	}
+	return fmt.Errorf("unsupported orphan kind %q", o.Kind)
+}
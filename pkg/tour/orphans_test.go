@@ -0,0 +1,157 @@
+package tour
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func orphanMeta(name string, age time.Duration) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace:         "shop",
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	zero := int32(0)
+	clientset := fake.NewClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{Name: "conf", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "web-conf"},
+					}}},
+					{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "web-data"}}},
+				},
+				Containers: []corev1.Container{{
+					Name:    "app",
+					EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-creds"}}}},
+				}},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: orphanMeta("batch", time.Hour),
+			Spec: appsv1.DeploymentSpec{Replicas: &zero, Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{{
+					Name: "MODE",
+					ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "batch-conf"}, Key: "mode",
+					}},
+				}}}},
+			}}},
+		},
+		&corev1.ServiceAccount{
+			ObjectMeta:       orphanMeta("deployer", time.Hour),
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+		},
+		&corev1.ConfigMap{ObjectMeta: orphanMeta("web-conf", time.Hour)},
+		&corev1.ConfigMap{ObjectMeta: orphanMeta("batch-conf", time.Hour)},
+		&corev1.ConfigMap{ObjectMeta: orphanMeta("old-conf", 48*time.Hour)},
+		&corev1.ConfigMap{ObjectMeta: orphanMeta("kube-root-ca.crt", time.Hour)},
+		&corev1.Secret{ObjectMeta: orphanMeta("web-creds", time.Hour)},
+		&corev1.Secret{ObjectMeta: orphanMeta("registry", time.Hour)},
+		&corev1.Secret{ObjectMeta: orphanMeta("leaked-token", time.Hour)},
+		&corev1.Secret{ObjectMeta: orphanMeta("sa-token", time.Hour), Type: corev1.SecretTypeServiceAccountToken},
+		&corev1.PersistentVolumeClaim{ObjectMeta: orphanMeta("web-data", time.Hour)},
+		&corev1.PersistentVolumeClaim{ObjectMeta: orphanMeta("old-data", time.Hour)},
+		&corev1.Service{ObjectMeta: orphanMeta("web", time.Hour), Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "web"}}},
+		&corev1.Service{ObjectMeta: orphanMeta("api", time.Hour), Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "api"}}},
+		&corev1.Service{ObjectMeta: orphanMeta("external", time.Hour)},
+		&appsv1.ReplicaSet{ObjectMeta: orphanMeta("web-5d8f", time.Hour), Spec: appsv1.ReplicaSetSpec{Replicas: &zero}},
+		// A revision of the batch Deployment is kept as its rollout history
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "batch-7c9d", OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: "batch", Controller: new(true),
+			}}},
+			Spec: appsv1.ReplicaSetSpec{Replicas: &zero},
+		},
+		// The claims of a StatefulSet scaled to zero outlive its pods
+		&appsv1.StatefulSet{
+			ObjectMeta: orphanMeta("db", time.Hour),
+			Spec: appsv1.StatefulSetSpec{Replicas: &zero, VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+			}},
+		},
+		&corev1.PersistentVolumeClaim{ObjectMeta: orphanMeta("data-db-0", time.Hour)},
+		&corev1.PersistentVolumeClaim{ObjectMeta: orphanMeta("data-db-3", time.Hour)},
+		&corev1.PersistentVolumeClaim{ObjectMeta: orphanMeta("data-db-old", time.Hour)},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cache-data", OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "StatefulSet", Name: "cache",
+		}}}},
+		// A CronJob only refers to its config between runs
+		&batchv1.CronJob{
+			ObjectMeta: orphanMeta("report", time.Hour),
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{Name: "conf", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "report-conf"},
+				}}}},
+				Containers: []corev1.Container{{
+					Name:    "report",
+					EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "report-creds"}}}},
+				}},
+			}}}}},
+		},
+		&corev1.ConfigMap{ObjectMeta: orphanMeta("report-conf", time.Hour)},
+		&corev1.Secret{ObjectMeta: orphanMeta("report-creds", time.Hour)},
+		&batchv1.Job{
+			ObjectMeta: orphanMeta("migrate", time.Hour),
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "migrate-pull"}},
+			}}},
+		},
+		&corev1.Secret{ObjectMeta: orphanMeta("migrate-pull", time.Hour)},
+		&networkingv1.Ingress{
+			ObjectMeta: orphanMeta("web", time.Hour),
+			Spec:       networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{{Hosts: []string{"shop.example.com"}, SecretName: "web-tls"}}},
+		},
+		&corev1.Secret{ObjectMeta: orphanMeta("web-tls", time.Hour)},
+	)
+
+	got, err := FindOrphans(clientset, "shop")
+	if err != nil {
+		t.Fatalf("FindOrphans() error = %v", err)
+	}
+	want := []string{
+		"ConfigMap/shop/old-conf",
+		"PersistentVolumeClaim/shop/data-db-old",
+		"PersistentVolumeClaim/shop/old-data",
+		"ReplicaSet/shop/web-5d8f",
+		"Secret/shop/leaked-token",
+		"Service/shop/api",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("FindOrphans() got = %+v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].key() != want[i] {
+			t.Errorf("FindOrphans() orphan %d = %s, want %s", i, got[i].key(), want[i])
+		}
+	}
+	if got[0].Age < 47*time.Hour {
+		t.Errorf("FindOrphans() age = %v, want about 48h", got[0].Age)
+	}
+
+	deleted, err := DeleteOrphans(clientset, got, 2)
+	if err != nil || deleted != len(want) {
+		t.Fatalf("DeleteOrphans() = %d, error = %v, want %d", deleted, err, len(want))
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("shop").Get(context.Background(), "old-conf", metav1.GetOptions{}); err == nil {
+		t.Errorf("DeleteOrphans() kept configmap old-conf")
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("shop").Get(context.Background(), "web-conf", metav1.GetOptions{}); err != nil {
+		t.Errorf("DeleteOrphans() removed referenced configmap web-conf: %v", err)
+	}
+	if again, err := FindOrphans(clientset, "shop"); err != nil || len(again) != 0 {
+		t.Errorf("FindOrphans() after delete = %+v, error = %v, want none", again, err)
+	}
+}
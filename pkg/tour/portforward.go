@@ -0,0 +1,403 @@
+package tour
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/streaming/pkg/httpstream"
+)
+
+// PortForwardOptions controls the local side and reconnection of a port-forward
+type PortForwardOptions struct {
+	// Address is the local address to listen on, 127.0.0.1 by default.
+	Address string
+	// LocalPort is the local port, 0 picks a free one reported by LocalPort().
+	LocalPort int
+	// RetryInterval and Timeout bound the search for a ready pod on reconnection.
+	RetryInterval time.Duration
+	Timeout       time.Duration
+}
+
+// PortForwarder forwards a local port to a ready pod behind a Service, moving to
+// another ready pod when the current one goes away
+type PortForwarder struct {
+	config    *rest.Config
+	client    rest.Interface
+	clientset kubernetes.Interface
+	namespace string
+	service   string
+	port      int32
+	opts      PortForwardOptions
+	listener  net.Listener
+	requestID atomic.Int64
+	// closing is cancelled by Close to interrupt a pending reconnection
+	closing context.Context
+	cancel  context.CancelFunc
+	// dialMu serializes the reconnections, it is never held by Close
+	dialMu sync.Mutex
+
+	mu         sync.Mutex
+	closed     bool
+	conn       httpstream.Connection
+	pod        string
+	targetPort int
+}
+
+// podConnection is a connection to a pod and the container port forwarded there
+type podConnection struct {
+	conn httpstream.Connection
+	pod  string
+	port int
+}
+
+// ForwardService listens locally and forwards connections to port of service
+// until ctx is done or Close is called.
+func ForwardService(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, namespace, service string, port int32, opts PortForwardOptions) (*PortForwarder, error) {
+	if opts.Address == "" {
+		opts.Address = "127.0.0.1"
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	streamClientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		slog.Error("create kube client failed", "error", err)
+		return nil, err
+	}
+	f := &PortForwarder{
+		config:    config,
+		client:    streamClientset.CoreV1().RESTClient(),
+		clientset: clientset,
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		opts:      opts,
+	}
+	f.closing, f.cancel = context.WithCancel(context.Background())
+	if _, err := f.connection(ctx); err != nil {
+		f.closeConnection()
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(opts.Address, strconv.Itoa(opts.LocalPort)))
+	if err != nil {
+		slog.Error("listen failed", "address", opts.Address, "port", opts.LocalPort, "error", err)
+		f.closeConnection()
+		return nil, err
+	}
+	f.listener = listener
+	slog.Info("port forwarding", "service", namespace+"/"+service, "port", port, "local", listener.Addr().String())
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go f.serve(ctx)
+	return f, nil
+}
+
+// LocalPort returns the bound local port
+func (f *PortForwarder) LocalPort() int {
+	return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Pod returns the pod currently forwarded to
+func (f *PortForwarder) Pod() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pod
+}
+
+// Close stops listening and disconnects from the pod
+func (f *PortForwarder) Close() error {
+	err := f.listener.Close()
+	f.closeConnection()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (f *PortForwarder) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *PortForwarder) closeConnection() {
+	f.cancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func (f *PortForwarder) serve(ctx context.Context) {
+	for {
+		local, err := f.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("accept failed", "error", err)
+			}
+			return
+		}
+		go f.handle(ctx, local)
+	}
+}
+
+// connection returns the pod connection, finding a ready pod and dialing it
+// when there is none or it was lost. The dial happens outside of mu so Close
+// can interrupt it.
+func (f *PortForwarder) connection(ctx context.Context) (podConnection, error) {
+	f.dialMu.Lock()
+	defer f.dialMu.Unlock()
+	if current, err := f.current(); current.conn != nil || err != nil {
+		return current, err
+	}
+
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(f.closing, cancel)
+	defer stop()
+
+	var conn httpstream.Connection
+	var pod string
+	var targetPort int
+	var lastErr error
+	err := wait.PollUntilContextTimeout(dialCtx, f.opts.RetryInterval, f.opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		pod, targetPort, err = f.readyPod(ctx)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		conn, err = f.dial(pod)
+		if err != nil {
+			lastErr = err
+			slog.Warn("dial pod failed, retrying", "namespace", f.namespace, "pod", pod, "error", err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if f.isClosed() {
+		if conn != nil {
+			conn.Close()
+		}
+		return podConnection{}, net.ErrClosed
+	}
+	if err != nil {
+		if lastErr != nil {
+			err = lastErr
+		}
+		slog.Error("no pod to forward to", "service", f.namespace+"/"+f.service, "error", err)
+		return podConnection{}, err
+	}
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		conn.Close()
+		return podConnection{}, net.ErrClosed
+	}
+	f.conn, f.pod, f.targetPort = conn, pod, targetPort
+	f.mu.Unlock()
+	slog.Info("forwarding to pod", "namespace", f.namespace, "pod", pod, "port", targetPort)
+
+	go func() {
+		<-conn.CloseChan()
+		if ctx.Err() != nil || f.isClosed() {
+			return
+		}
+		slog.Warn("pod connection lost, reconnecting", "namespace", f.namespace, "pod", pod)
+		f.connection(ctx)
+	}()
+	return podConnection{conn: conn, pod: pod, port: targetPort}, nil
+}
+
+// current returns the live pod connection with its pod and port, a nil conn
+// when there is none
+func (f *PortForwarder) current() (podConnection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return podConnection{}, net.ErrClosed
+	}
+	if f.conn == nil {
+		return podConnection{}, nil
+	}
+	select {
+	case <-f.conn.CloseChan():
+		return podConnection{}, nil
+	default:
+		return podConnection{conn: f.conn, pod: f.pod, port: f.targetPort}, nil
+	}
+}
+
+// drop closes the pod connection so the next one goes to a ready pod again
+func (f *PortForwarder) drop(conn httpstream.Connection) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == conn {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func (f *PortForwarder) dial(pod string) (httpstream.Connection, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(f.config)
+	if err != nil {
+		return nil, err
+	}
+	u := f.client.Post().Resource("pods").Namespace(f.namespace).Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialerForStreaming(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	return conn, err
+}
+
+// readyPod picks a running, ready pod selected by the service and resolves the
+// service port to the container port
+func (f *PortForwarder) readyPod(ctx context.Context) (string, int, error) {
+	svc, err := f.clientset.CoreV1().Services(f.namespace).Get(ctx, f.service, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, err
+	}
+	var servicePort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == f.port {
+			servicePort = &svc.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return "", 0, fmt.Errorf("service %s/%s has no port %d", f.namespace, f.service, f.port)
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return "", 0, fmt.Errorf("service %s/%s has no selector", f.namespace, f.service)
+	}
+
+	pods, err := f.clientset.CoreV1().Pods(f.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if c := podCondition(pod, corev1.PodReady); c == nil || c.Status != corev1.ConditionTrue {
+			continue
+		}
+		if port, ok := containerPort(pod, servicePort); ok {
+			return pod.Name, port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no ready pod behind service %s/%s", f.namespace, f.service)
+}
+
+func containerPort(pod *corev1.Pod, servicePort *corev1.ServicePort) (int, bool) {
+	target := servicePort.TargetPort
+	switch {
+	case target.Type == intstr.String:
+		for _, c := range pod.Spec.Containers {
+			for _, p := range c.Ports {
+				if p.Name == target.StrVal {
+					return int(p.ContainerPort), true
+				}
+			}
+		}
+		return 0, false
+	case target.IntVal != 0:
+		return int(target.IntVal), true
+	}
+	return int(servicePort.Port), true
+}
+
+// handle forwards one local connection over a data and error stream pair, the
+// port-forward protocol kubectl uses
+func (f *PortForwarder) handle(ctx context.Context, local net.Conn) {
+	defer local.Close()
+	target, err := f.connection(ctx)
+	if err != nil {
+		return
+	}
+	conn, pod, port := target.conn, target.pod, target.port
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.FormatInt(f.requestID.Add(1), 10))
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		slog.Error("create error stream failed", "pod", pod, "error", err)
+		return
+	}
+	// Only the pod writes to the error stream
+	errorStream.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errCh <- fmt.Errorf("read error stream: %w", err)
+		case len(message) > 0:
+			// The pod failed the forward, like a port it does not listen on
+			// anymore, the next connection goes to a ready pod again
+			errCh <- fmt.Errorf("forward port %d of pod %s: %s", port, pod, message)
+			slog.Warn("pod refused forwarding, reconnecting", "namespace", f.namespace, "pod", pod, "error", string(message))
+			f.drop(conn)
+		}
+		close(errCh)
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		slog.Error("create data stream failed", "pod", pod, "error", err)
+		return
+	}
+	defer conn.RemoveStreams(dataStream, errorStream)
+
+	remoteDone := make(chan struct{})
+	go func() {
+		io.Copy(local, dataStream)
+		close(remoteDone)
+	}()
+	go func() {
+		io.Copy(dataStream, local)
+		dataStream.Close()
+	}()
+
+	select {
+	case <-remoteDone:
+	case <-conn.CloseChan():
+	case <-ctx.Done():
+	}
+	if err := <-errCh; err != nil {
+		slog.Error("port forward failed", "pod", pod, "error", err)
+	}
+}
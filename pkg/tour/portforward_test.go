@@ -0,0 +1,266 @@
+package tour
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/streaming/pkg/httpstream"
+	"k8s.io/streaming/pkg/httpstream/spdy"
+)
+
+// portForwardServer answers each forwarded connection with "pod:port" and then
+// echoes, or reports an error on the error stream for the refusing pods
+type portForwardServer struct {
+	mu      sync.Mutex
+	conns   map[string][]httpstream.Connection
+	refused map[string]bool
+}
+
+func (s *portForwardServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 7 || parts[6] != "portforward" {
+		http.NotFound(w, req)
+		return
+	}
+	pod := parts[5]
+	if _, err := httpstream.Handshake(req, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		return
+	}
+
+	errorStreams := map[string]httpstream.Stream{}
+	var mu sync.Mutex
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(stream httpstream.Stream, _ <-chan struct{}) error {
+		headers := stream.Headers()
+		id := headers.Get(corev1.PortForwardRequestIDHeader)
+		mu.Lock()
+		defer mu.Unlock()
+		if headers.Get(corev1.StreamType) == corev1.StreamTypeError {
+			errorStreams[id] = stream
+			return nil
+		}
+		errorStream := errorStreams[id]
+		s.mu.Lock()
+		refused := s.refused[pod]
+		s.mu.Unlock()
+		go func() {
+			if refused && errorStream != nil {
+				fmt.Fprintf(errorStream, "connection refused")
+				errorStream.Close()
+				stream.Close()
+				return
+			}
+			fmt.Fprintf(stream, "%s:%s\n", pod, headers.Get(corev1.PortHeader))
+			io.Copy(stream, stream)
+			stream.Close()
+			if errorStream != nil {
+				errorStream.Close()
+			}
+		}()
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[pod] = append(s.conns[pod], conn)
+	s.mu.Unlock()
+	<-conn.CloseChan()
+}
+
+// refuse makes pod fail the new forwards while keeping its connections
+func (s *portForwardServer) refuse(pod string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refused[pod] = true
+}
+
+// kill drops the connections to pod like a pod going away
+func (s *portForwardServer) kill(pod string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns[pod] {
+		conn.Close()
+	}
+	delete(s.conns, pod)
+}
+
+func forwardPod(name string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Labels: map[string]string{"app": "memcached"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "memcached",
+			Ports: []corev1.ContainerPort{{Name: "memcache", ContainerPort: 11211}},
+		}}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func roundTrip(t *testing.T, port int, message string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("dial forwarded port: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read greeting: %v", err)
+	}
+	fmt.Fprintln(conn, message)
+	echo, err := r.ReadString('\n')
+	if err != nil || echo != message+"\n" {
+		t.Fatalf("echo = %q, error = %v, want %q", echo, err, message)
+	}
+	return strings.TrimSpace(greeting)
+}
+
+func TestForwardService(t *testing.T) {
+	server := &portForwardServer{conns: map[string][]httpstream.Connection{}, refused: map[string]bool{}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	clientset := fake.NewClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "memcached"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "memcached"},
+				Ports:    []corev1.ServicePort{{Port: 11211, TargetPort: intstr.FromString("memcache")}},
+			},
+		},
+		forwardPod("memcached-a", true),
+		forwardPod("memcached-b", false),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := &rest.Config{Host: srv.URL}
+	opts := PortForwardOptions{RetryInterval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+
+	f, err := ForwardService(ctx, config, clientset, "shop", "memcached", 11211, opts)
+	if err != nil {
+		t.Fatalf("ForwardService() error = %v", err)
+	}
+	if f.LocalPort() == 0 {
+		t.Fatalf("LocalPort() = 0, want the bound port")
+	}
+	if got := roundTrip(t, f.LocalPort(), "ping"); got != "memcached-a:11211" {
+		t.Errorf("forwarded to %s, want memcached-a:11211", got)
+	}
+
+	// memcached-a dies and memcached-b becomes ready
+	for _, pod := range []*corev1.Pod{forwardPod("memcached-a", false), forwardPod("memcached-b", true)} {
+		if _, err := clientset.CoreV1().Pods("shop").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.kill("memcached-a")
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return f.Pod() == "memcached-b", nil
+	})
+	if err != nil {
+		t.Fatalf("Pod() = %s after memcached-a died, want memcached-b", f.Pod())
+	}
+	if got := roundTrip(t, f.LocalPort(), "pong"); got != "memcached-b:11211" {
+		t.Errorf("forwarded to %s, want memcached-b:11211", got)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", f.LocalPort())); err == nil {
+		conn.Close()
+		t.Errorf("local port still accepting after Close()")
+	}
+
+	opts.Timeout = 50 * time.Millisecond
+	if _, err := ForwardService(ctx, config, clientset, "shop", "memcached", 9999, opts); err == nil || !strings.Contains(err.Error(), "no port 9999") {
+		t.Errorf("ForwardService() unknown port error = %v", err)
+	}
+}
+
+func TestForwardServiceRedial(t *testing.T) {
+	server := &portForwardServer{conns: map[string][]httpstream.Connection{}, refused: map[string]bool{}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	clientset := fake.NewClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "memcached"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "memcached"},
+				Ports:    []corev1.ServicePort{{Port: 11211, TargetPort: intstr.FromString("memcache")}},
+			},
+		},
+		forwardPod("memcached-a", true),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := PortForwardOptions{RetryInterval: 10 * time.Millisecond, Timeout: time.Minute}
+	f, err := ForwardService(ctx, &rest.Config{Host: srv.URL}, clientset, "shop", "memcached", 11211, opts)
+	if err != nil {
+		t.Fatalf("ForwardService() error = %v", err)
+	}
+
+	// memcached-a stops listening while its connection stays up
+	server.refuse("memcached-a")
+	if _, err := clientset.CoreV1().Pods("shop").Update(ctx, forwardPod("memcached-a", false), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Pods("shop").Create(ctx, forwardPod("memcached-b", true), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", f.LocalPort())); err == nil {
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return f.Pod() == "memcached-b", nil
+	})
+	if err != nil {
+		t.Fatalf("Pod() = %s after memcached-a refused, want memcached-b", f.Pod())
+	}
+	if got := roundTrip(t, f.LocalPort(), "ping"); got != "memcached-b:11211" {
+		t.Errorf("forwarded to %s, want memcached-b:11211", got)
+	}
+
+	// No pod is ready anymore, Close interrupts the reconnection
+	if _, err := clientset.CoreV1().Pods("shop").Update(ctx, forwardPod("memcached-b", false), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	server.kill("memcached-b")
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Close() blocked by the pending reconnection")
+	}
+}
@@ -0,0 +1,224 @@
+package tour
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const rbacMarkerPrefix = "+kubebuilder:rbac:"
+
+// AccessCheck is one (verb, group, resource, namespace) tuple to review
+type AccessCheck struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+}
+
+func (c AccessCheck) resourceName() string {
+	name := c.Resource
+	if c.Subresource != "" {
+		name += "/" + c.Subresource
+	}
+	if c.Group != "" {
+		name += "." + c.Group
+	}
+	return name
+}
+
+func (c AccessCheck) attributes() *authzv1.ResourceAttributes {
+	return &authzv1.ResourceAttributes{
+		Namespace:   c.Namespace,
+		Verb:        c.Verb,
+		Group:       c.Group,
+		Resource:    c.Resource,
+		Subresource: c.Subresource,
+	}
+}
+
+// AccessResult is the review outcome of one AccessCheck
+type AccessResult struct {
+	AccessCheck
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// CheckAccess reviews the checks for the current identity with SelfSubjectAccessReview
+func CheckAccess(clientset kubernetes.Interface, checks []AccessCheck) ([]AccessResult, error) {
+	results := make([]AccessResult, 0, len(checks))
+	for _, c := range checks {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(),
+			&authzv1.SelfSubjectAccessReview{
+				Spec: authzv1.SelfSubjectAccessReviewSpec{ResourceAttributes: c.attributes()},
+			}, metav1.CreateOptions{},
+		)
+		if err != nil {
+			slog.Error("create selfsubjectaccessreview failed", "check", c, "error", err)
+			return nil, err
+		}
+		results = append(results, accessResult(c, review.Status))
+	}
+	return results, nil
+}
+
+// CheckServiceAccountAccess reviews the checks for a ServiceAccount with SubjectAccessReview
+func CheckServiceAccountAccess(clientset kubernetes.Interface, namespace, name string, checks []AccessCheck) ([]AccessResult, error) {
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+	groups := []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"}
+
+	results := make([]AccessResult, 0, len(checks))
+	for _, c := range checks {
+		review, err := clientset.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(),
+			&authzv1.SubjectAccessReview{
+				Spec: authzv1.SubjectAccessReviewSpec{
+					ResourceAttributes: c.attributes(),
+					User:               user,
+					Groups:             groups,
+				},
+			}, metav1.CreateOptions{},
+		)
+		if err != nil {
+			slog.Error("create subjectaccessreview failed", "user", user, "check", c, "error", err)
+			return nil, err
+		}
+		results = append(results, accessResult(c, review.Status))
+	}
+	return results, nil
+}
+
+func accessResult(c AccessCheck, status authzv1.SubjectAccessReviewStatus) AccessResult {
+	reason := status.Reason
+	if status.EvaluationError != "" {
+		reason = strings.TrimSpace(reason + " " + status.EvaluationError)
+	}
+	return AccessResult{AccessCheck: c, Allowed: status.Allowed && !status.Denied, Reason: reason}
+}
+
+// ParseRBACMarkers builds the checks declared by +kubebuilder:rbac markers of a Go file
+func ParseRBACMarkers(fpath string) ([]AccessCheck, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		slog.Error("open file failed", "path", fpath, "error", err)
+		return nil, err
+	}
+	defer f.Close()
+	return parseRBACMarkers(f)
+}
+
+func parseRBACMarkers(r io.Reader) ([]AccessCheck, error) {
+	checks := []AccessCheck{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "//") {
+			continue
+		}
+		marker, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimPrefix(text, "//")), rbacMarkerPrefix)
+		if !ok {
+			continue
+		}
+		parsed, err := parseRBACMarker(marker)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		checks = append(checks, parsed...)
+	}
+	return checks, scanner.Err()
+}
+
+// parseRBACMarker expands groups=a;b,resources=x;y/status,verbs=get;list into every combination
+func parseRBACMarker(marker string) ([]AccessCheck, error) {
+	args := map[string][]string{}
+	for _, kv := range strings.Split(marker, ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rbac marker argument %q", kv)
+		}
+		args[strings.TrimSpace(key)] = strings.Split(strings.Trim(strings.TrimSpace(value), `"{}`), ";")
+	}
+	if _, ok := args["urls"]; ok {
+		slog.Warn("non-resource rbac marker skipped", "marker", marker)
+		return nil, nil
+	}
+	if len(args["resources"]) == 0 || len(args["verbs"]) == 0 {
+		return nil, fmt.Errorf("rbac marker %q needs resources and verbs", marker)
+	}
+
+	groups := args["groups"]
+	if len(groups) == 0 {
+		groups = []string{""}
+	}
+	namespace := ""
+	if ns := args["namespace"]; len(ns) > 0 {
+		namespace = ns[0]
+	}
+
+	checks := []AccessCheck{}
+	for _, group := range groups {
+		if group == "core" {
+			group = ""
+		}
+		for _, res := range args["resources"] {
+			resource, subresource, _ := strings.Cut(res, "/")
+			for _, verb := range args["verbs"] {
+				checks = append(checks, AccessCheck{
+					Verb:        verb,
+					Group:       group,
+					Resource:    resource,
+					Subresource: subresource,
+					Namespace:   namespace,
+				})
+			}
+		}
+	}
+	return checks, nil
+}
+
+// WriteAccessMatrix renders results as a resource by verb matrix
+func WriteAccessMatrix(w io.Writer, results []AccessResult) error {
+	verbs := []string{}
+	rows := map[string]map[string]string{}
+	rowNames := []string{}
+	for _, r := range results {
+		row := r.resourceName()
+		if r.Namespace != "" {
+			row = r.Namespace + "/" + row
+		}
+		if _, ok := rows[row]; !ok {
+			rows[row] = map[string]string{}
+			rowNames = append(rowNames, row)
+		}
+		if !slices.Contains(verbs, r.Verb) {
+			verbs = append(verbs, r.Verb)
+		}
+		rows[row][r.Verb] = "no"
+		if r.Allowed {
+			rows[row][r.Verb] = "yes"
+		}
+	}
+	sort.Strings(rowNames)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "RESOURCE\t%s\n", strings.ToUpper(strings.Join(verbs, "\t")))
+	for _, row := range rowNames {
+		cells := make([]string, len(verbs))
+		for i, verb := range verbs {
+			cells[i] = orDash(rows[row][verb])
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", row, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
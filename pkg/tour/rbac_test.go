@@ -0,0 +1,124 @@
+package tour
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseRBACMarkers(t *testing.T) {
+	got, err := ParseRBACMarkers("../../app/memcached-operator/internal/controller/memcached_controller.go")
+	if err != nil {
+		t.Fatalf("ParseRBACMarkers() error = %v", err)
+	}
+	if len(got) != 11 {
+		t.Fatalf("ParseRBACMarkers() got %d checks, want 11: %+v", len(got), got)
+	}
+	want := AccessCheck{Verb: "update", Group: "cache.urans.com", Resource: "memcacheds", Subresource: "finalizers"}
+	if got[len(got)-1] != want {
+		t.Errorf("ParseRBACMarkers() last check = %+v, want %+v", got[len(got)-1], want)
+	}
+
+	if _, err := ParseRBACMarkers("not-exist.go"); err == nil {
+		t.Errorf("ParseRBACMarkers() error = nil, want error")
+	}
+}
+
+func TestParseRBACMarker(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  string
+		want    int
+		wantErr bool
+	}{
+		{"core", "groups=core,resources=pods;events,verbs=get;list,namespace=system", 4, false},
+		{"multi-group", "groups=apps;batch,resources=deployments,verbs=get", 2, false},
+		{"no-group", "resources=configmaps,verbs=*", 1, false},
+		{"urls", `urls=/metrics,verbs=get`, 0, false},
+		{"no-verbs", "groups=apps,resources=deployments", 0, true},
+		{"malformed", "groups", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRBACMarker(tt.marker)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRBACMarker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("parseRBACMarker() got = %+v, want %d checks", got, tt.want)
+			}
+			for _, c := range got {
+				if tt.name == "core" && (c.Group != "" || c.Namespace != "system") {
+					t.Errorf("parseRBACMarker() core check = %+v", c)
+				}
+			}
+		})
+	}
+}
+
+// allowReactor allows reviews for resources listed in allowed and records the reviewed users
+func allowReactor(allowed map[string]bool, users *[]string) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch review := action.(k8stesting.CreateAction).GetObject().(type) {
+		case *authzv1.SelfSubjectAccessReview:
+			review.Status.Allowed = allowed[review.Spec.ResourceAttributes.Resource]
+			return true, review, nil
+		case *authzv1.SubjectAccessReview:
+			*users = append(*users, review.Spec.User)
+			review.Status.Allowed = allowed[review.Spec.ResourceAttributes.Resource]
+			if !review.Status.Allowed {
+				review.Status.Reason = "no RBAC policy matched"
+			}
+			return true, review, nil
+		}
+		return false, nil, nil
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	checks := []AccessCheck{
+		{Verb: "get", Group: "cache.urans.com", Resource: "memcacheds"},
+		{Verb: "create", Group: "apps", Resource: "deployments", Namespace: "default"},
+	}
+	allowed := map[string]bool{"memcacheds": true}
+	users := []string{}
+
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", allowReactor(allowed, &users))
+	clientset.PrependReactor("create", "subjectaccessreviews", allowReactor(allowed, &users))
+
+	self, err := CheckAccess(clientset, checks)
+	if err != nil {
+		t.Fatalf("CheckAccess() error = %v", err)
+	}
+	sa, err := CheckServiceAccountAccess(clientset, "memcached-operator-system", "controller-manager", checks)
+	if err != nil {
+		t.Fatalf("CheckServiceAccountAccess() error = %v", err)
+	}
+	for _, results := range [][]AccessResult{self, sa} {
+		if len(results) != 2 || !results[0].Allowed || results[1].Allowed {
+			t.Errorf("access results = %+v, want memcacheds allowed and deployments denied", results)
+		}
+	}
+	if sa[1].Reason == "" {
+		t.Errorf("CheckServiceAccountAccess() denied reason is empty")
+	}
+	if users[0] != "system:serviceaccount:memcached-operator-system:controller-manager" {
+		t.Errorf("CheckServiceAccountAccess() user = %s", users[0])
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteAccessMatrix(buf, self); err != nil {
+		t.Fatalf("WriteAccessMatrix() error = %v", err)
+	}
+	for _, want := range []string{"RESOURCE", "GET", "CREATE", "default/deployments.apps", "memcacheds.cache.urans.com  yes"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteAccessMatrix() = %s, want to contain %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package tour
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResourceTotals sums container requests and limits
+type ResourceTotals struct {
+	CPURequests    resource.Quantity `json:"cpuRequests"`
+	CPULimits      resource.Quantity `json:"cpuLimits"`
+	MemoryRequests resource.Quantity `json:"memoryRequests"`
+	MemoryLimits   resource.Quantity `json:"memoryLimits"`
+}
+
+func (t *ResourceTotals) add(requests, limits corev1.ResourceList) {
+	t.CPURequests.Add(requests[corev1.ResourceCPU])
+	t.CPULimits.Add(limits[corev1.ResourceCPU])
+	t.MemoryRequests.Add(requests[corev1.ResourceMemory])
+	t.MemoryLimits.Add(limits[corev1.ResourceMemory])
+}
+
+// NodeUsage compares the pods scheduled on a node to its allocatable resources
+type NodeUsage struct {
+	Name string `json:"name"`
+	Pods int    `json:"pods"`
+	ResourceTotals
+	AllocatableCPU    resource.Quantity `json:"allocatableCpu"`
+	AllocatableMemory resource.Quantity `json:"allocatableMemory"`
+	// Ratios of requests and limits to allocatable, limits above 1 mean overcommit.
+	CPURequestRatio    float64 `json:"cpuRequestRatio"`
+	CPULimitRatio      float64 `json:"cpuLimitRatio"`
+	MemoryRequestRatio float64 `json:"memoryRequestRatio"`
+	MemoryLimitRatio   float64 `json:"memoryLimitRatio"`
+}
+
+// QuotaUsage is the use of a single ResourceQuota resource
+type QuotaUsage struct {
+	Quota    string            `json:"quota"`
+	Resource string            `json:"resource"`
+	Used     resource.Quantity `json:"used"`
+	Hard     resource.Quantity `json:"hard"`
+	Ratio    float64           `json:"ratio"`
+}
+
+// NamespaceUsage sums the pods of a namespace and compares them to its quotas and limit ranges
+type NamespaceUsage struct {
+	Name string `json:"name"`
+	Pods int    `json:"pods"`
+	ResourceTotals
+	PodsWithoutRequests  []string     `json:"podsWithoutRequests,omitempty"`
+	Quotas               []QuotaUsage `json:"quotas,omitempty"`
+	NearQuota            bool         `json:"nearQuota"`
+	LimitRangeViolations []string     `json:"limitRangeViolations,omitempty"`
+}
+
+// ResourceReport is the per namespace and per node resource report
+type ResourceReport struct {
+	Namespaces []NamespaceUsage `json:"namespaces"`
+	Nodes      []NodeUsage      `json:"nodes"`
+}
+
+// DefaultNearQuotaRatio is the quota usage from which a namespace is near its quota
+const DefaultNearQuotaRatio = 0.9
+
+// BuildResourceReport sums requests and limits of running pods per namespace and
+// node, flagging namespaces whose quota usage reaches nearQuota.
+func BuildResourceReport(clientset kubernetes.Interface, nearQuota float64) (*ResourceReport, error) {
+	if nearQuota <= 0 {
+		nearQuota = DefaultNearQuotaRatio
+	}
+	pods, err := ListPods(clientset, metav1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := ListNodes(clientset, nil)
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := clientset.CoreV1().ResourceQuotas(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		slog.Error("list resourcequotas failed", "error", err)
+		return nil, err
+	}
+	limitRanges, err := clientset.CoreV1().LimitRanges(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		slog.Error("list limitranges failed", "error", err)
+		return nil, err
+	}
+	return buildResourceReport(pods, nodes, quotas.Items, limitRanges.Items, nearQuota), nil
+}
+
+func buildResourceReport(pods []corev1.Pod, nodes []corev1.Node, quotas []corev1.ResourceQuota, limitRanges []corev1.LimitRange, nearQuota float64) *ResourceReport {
+	namespaces := map[string]*NamespaceUsage{}
+	nodeUsage := map[string]*NodeUsage{}
+	for _, n := range nodes {
+		nodeUsage[n.Name] = &NodeUsage{
+			Name:              n.Name,
+			AllocatableCPU:    n.Status.Allocatable[corev1.ResourceCPU],
+			AllocatableMemory: n.Status.Allocatable[corev1.ResourceMemory],
+		}
+	}
+	namespace := func(name string) *NamespaceUsage {
+		if _, ok := namespaces[name]; !ok {
+			namespaces[name] = &NamespaceUsage{Name: name}
+		}
+		return namespaces[name]
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests, limits := podRequestsAndLimits(pod)
+		ns := namespace(pod.Namespace)
+		ns.Pods++
+		ns.add(requests, limits)
+		if len(requests) == 0 {
+			ns.PodsWithoutRequests = append(ns.PodsWithoutRequests, pod.Name)
+		}
+		for _, lr := range limitRanges {
+			if lr.Namespace == pod.Namespace {
+				ns.LimitRangeViolations = append(ns.LimitRangeViolations, limitRangeViolations(pod, &lr)...)
+			}
+		}
+		if n, ok := nodeUsage[pod.Spec.NodeName]; ok {
+			n.Pods++
+			n.add(requests, limits)
+		}
+	}
+
+	for _, q := range quotas {
+		ns := namespace(q.Namespace)
+		names := make([]string, 0, len(q.Status.Hard))
+		for name := range q.Status.Hard {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			hard := q.Status.Hard[corev1.ResourceName(name)]
+			used := q.Status.Used[corev1.ResourceName(name)]
+			u := QuotaUsage{Quota: q.Name, Resource: name, Used: used, Hard: hard, Ratio: ratio(used, hard)}
+			ns.Quotas = append(ns.Quotas, u)
+			ns.NearQuota = ns.NearQuota || u.Ratio >= nearQuota
+		}
+	}
+
+	report := &ResourceReport{}
+	for _, ns := range namespaces {
+		report.Namespaces = append(report.Namespaces, *ns)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool { return report.Namespaces[i].Name < report.Namespaces[j].Name })
+	for _, n := range nodeUsage {
+		n.CPURequestRatio = ratio(n.CPURequests, n.AllocatableCPU)
+		n.CPULimitRatio = ratio(n.CPULimits, n.AllocatableCPU)
+		n.MemoryRequestRatio = ratio(n.MemoryRequests, n.AllocatableMemory)
+		n.MemoryLimitRatio = ratio(n.MemoryLimits, n.AllocatableMemory)
+		report.Nodes = append(report.Nodes, *n)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Name < report.Nodes[j].Name })
+	return report
+}
+
+// podRequestsAndLimits computes the effective pod resources like the scheduler,
+// the sum of containers or the largest init container, whichever is bigger.
+func podRequestsAndLimits(pod *corev1.Pod) (corev1.ResourceList, corev1.ResourceList) {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, c := range pod.Spec.Containers {
+		addResourceList(requests, c.Resources.Requests)
+		addResourceList(limits, c.Resources.Limits)
+	}
+	for _, c := range pod.Spec.InitContainers {
+		maxResourceList(requests, c.Resources.Requests)
+		maxResourceList(limits, c.Resources.Limits)
+	}
+	for name, q := range pod.Spec.Overhead {
+		if v, ok := requests[name]; ok {
+			v.Add(q)
+			requests[name] = v
+		}
+	}
+	return requests, limits
+}
+
+func addResourceList(list, add corev1.ResourceList) {
+	for name, q := range add {
+		v := list[name]
+		v.Add(q)
+		list[name] = v
+	}
+}
+
+func maxResourceList(list, other corev1.ResourceList) {
+	for name, q := range other {
+		if v, ok := list[name]; !ok || q.Cmp(v) > 0 {
+			list[name] = q.DeepCopy()
+		}
+	}
+}
+
+func limitRangeViolations(pod *corev1.Pod, lr *corev1.LimitRange) []string {
+	violations := []string{}
+	for _, item := range lr.Spec.Limits {
+		if item.Type != corev1.LimitTypeContainer {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			for name, maxQ := range item.Max {
+				if limit, ok := c.Resources.Limits[name]; ok && limit.Cmp(maxQ) > 0 {
+					violations = append(violations, fmt.Sprintf("%s/%s: %s limit %s above %s max %s",
+						pod.Name, c.Name, name, limit.String(), lr.Name, maxQ.String()))
+				}
+			}
+			for name, minQ := range item.Min {
+				if request, ok := c.Resources.Requests[name]; ok && request.Cmp(minQ) < 0 {
+					violations = append(violations, fmt.Sprintf("%s/%s: %s request %s below %s min %s",
+						pod.Name, c.Name, name, request.String(), lr.Name, minQ.String()))
+				}
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+func ratio(used, total resource.Quantity) float64 {
+	if total.IsZero() {
+		return 0
+	}
+	return used.AsApproximateFloat64() / total.AsApproximateFloat64()
+}
+
+// WriteResourceReport renders the report as table or json
+func WriteResourceReport(w io.Writer, format string, report *ResourceReport) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAMESPACE\tPODS\tCPU-REQ\tCPU-LIM\tMEM-REQ\tMEM-LIM\tNO-REQUESTS\tQUOTA")
+		for _, ns := range report.Namespaces {
+			quota := "-"
+			if len(ns.Quotas) > 0 {
+				quota = fmt.Sprintf("%.0f%%", maxQuotaRatio(ns.Quotas)*100)
+				if ns.NearQuota {
+					quota += " (near)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%d\t%s\n", ns.Name, ns.Pods,
+				ns.CPURequests.String(), ns.CPULimits.String(), ns.MemoryRequests.String(), ns.MemoryLimits.String(),
+				len(ns.PodsWithoutRequests), quota)
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "NODE\tPODS\tCPU-REQ\tCPU-LIM\tMEM-REQ\tMEM-LIM\tOVERCOMMIT")
+		for _, n := range report.Nodes {
+			overcommit := []string{}
+			if n.CPULimitRatio > 1 {
+				overcommit = append(overcommit, fmt.Sprintf("cpu %.2fx", n.CPULimitRatio))
+			}
+			if n.MemoryLimitRatio > 1 {
+				overcommit = append(overcommit, fmt.Sprintf("memory %.2fx", n.MemoryLimitRatio))
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%.0f%%\t%.0f%%\t%.0f%%\t%s\n", n.Name, n.Pods,
+				n.CPURequestRatio*100, n.CPULimitRatio*100, n.MemoryRequestRatio*100, n.MemoryLimitRatio*100,
+				orDash(strings.Join(overcommit, ",")))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func maxQuotaRatio(quotas []QuotaUsage) float64 {
+	highest := 0.0
+	for _, q := range quotas {
+		highest = max(highest, q.Ratio)
+	}
+	return highest
+}
@@ -0,0 +1,133 @@
+package tour
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if cpu != "" {
+		list[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func resourcePod(namespace, name, node string, requests, limits corev1.ResourceList) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name:      "app",
+				Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestBuildResourceReport(t *testing.T) {
+	initPod := resourcePod("shop", "migrate", "node-a", resourceList("100m", "64Mi"), nil)
+	initPod.Spec.InitContainers = []corev1.Container{{
+		Name:      "init",
+		Resources: corev1.ResourceRequirements{Requests: resourceList("500m", "")},
+	}}
+	done := resourcePod("shop", "done", "node-a", resourceList("4", "4Gi"), nil)
+	done.Status.Phase = corev1.PodSucceeded
+
+	clientset := fake.NewClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Status:     corev1.NodeStatus{Allocatable: resourceList("2", "4Gi")},
+		},
+		resourcePod("shop", "web", "node-a", resourceList("500m", "1Gi"), resourceList("2", "6Gi")),
+		resourcePod("shop", "naked", "node-a", nil, nil),
+		initPod,
+		done,
+		resourcePod("ops", "tool", "node-a", resourceList("250m", "256Mi"), resourceList("2", "256Mi")),
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "compute"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1"), corev1.ResourcePods: resource.MustParse("10")},
+				Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("950m"), corev1.ResourcePods: resource.MustParse("3")},
+			},
+		},
+		&corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "limits"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Max:  resourceList("1", ""),
+			}}},
+		},
+	)
+
+	report, err := BuildResourceReport(clientset, 0)
+	if err != nil {
+		t.Fatalf("BuildResourceReport() error = %v", err)
+	}
+	if len(report.Namespaces) != 2 || len(report.Nodes) != 1 {
+		t.Fatalf("BuildResourceReport() got = %+v", report)
+	}
+
+	ops, shop := report.Namespaces[0], report.Namespaces[1]
+	if shop.Pods != 3 {
+		t.Errorf("shop pods = %d, want 3", shop.Pods)
+	}
+	if got := shop.CPURequests.String(); got != "1" {
+		t.Errorf("shop cpu requests = %s, want 1 (500m web + 500m init)", got)
+	}
+	if len(shop.PodsWithoutRequests) != 1 || shop.PodsWithoutRequests[0] != "naked" {
+		t.Errorf("shop pods without requests = %v, want [naked]", shop.PodsWithoutRequests)
+	}
+	if !shop.NearQuota || len(shop.Quotas) != 2 {
+		t.Errorf("shop quotas = %+v, near = %v, want near quota", shop.Quotas, shop.NearQuota)
+	}
+	if ops.NearQuota || len(ops.LimitRangeViolations) != 1 {
+		t.Errorf("ops = %+v, want one limit range violation", ops)
+	}
+
+	node := report.Nodes[0]
+	if node.Pods != 4 {
+		t.Errorf("node pods = %d, want 4", node.Pods)
+	}
+	if math.Abs(node.CPULimitRatio-2) > 0.01 {
+		t.Errorf("node cpu limit ratio = %v, want 2", node.CPULimitRatio)
+	}
+	if math.Abs(node.MemoryLimitRatio-1.5625) > 0.01 {
+		t.Errorf("node memory limit ratio = %v, want 1.5625", node.MemoryLimitRatio)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteResourceReport(buf, "table", report); err != nil {
+		t.Fatalf("WriteResourceReport() error = %v", err)
+	}
+	for _, want := range []string{"shop", "95% (near)", "cpu 2.00x", "memory 1.56x"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteResourceReport() table = %s, want to contain %q", buf.String(), want)
+		}
+	}
+
+	buf.Reset()
+	if err := WriteResourceReport(buf, "json", report); err != nil {
+		t.Fatalf("WriteResourceReport() error = %v", err)
+	}
+	decoded := ResourceReport{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Namespaces) != 2 {
+		t.Errorf("WriteResourceReport() json = %+v, error = %v", decoded, err)
+	}
+	if err := WriteResourceReport(buf, "yaml", report); err == nil {
+		t.Errorf("WriteResourceReport() unknown format error = nil")
+	}
+}
@@ -0,0 +1,277 @@
+package tour
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	toolwatch "k8s.io/client-go/tools/watch"
+)
+
+// WorkloadKind is a workload type which supports rollouts
+type WorkloadKind string
+
+const (
+	KindDeployment  WorkloadKind = "Deployment"
+	KindStatefulSet WorkloadKind = "StatefulSet"
+	KindDaemonSet   WorkloadKind = "DaemonSet"
+)
+
+// ErrProgressDeadlineExceeded is returned when a Deployment stopped progressing
+var ErrProgressDeadlineExceeded = errors.New("progress deadline exceeded")
+
+// ErrRolloutPaused is returned when a Deployment is paused, its rollout cannot complete
+var ErrRolloutPaused = errors.New("rollout paused")
+
+// Workload identifies a Deployment, StatefulSet or DaemonSet
+type Workload struct {
+	Kind      WorkloadKind
+	Namespace string
+	Name      string
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// RolloutResult is the latest observed rollout state of a workload
+type RolloutResult struct {
+	Workload
+	Done               bool
+	Paused             bool
+	Message            string
+	Generation         int64
+	ObservedGeneration int64
+	Desired            int32
+	Updated            int32
+	Available          int32
+}
+
+// WaitForRollout watches the workload until its rollout completes, fails or ctx is done
+func WaitForRollout(ctx context.Context, clientset kubernetes.Interface, w Workload) (RolloutResult, error) {
+	lw, obj, err := rolloutListWatch(clientset, w)
+	if err != nil {
+		return RolloutResult{Workload: w}, err
+	}
+
+	result := RolloutResult{Workload: w, Message: "waiting for " + w.String()}
+	// A workload missing once synced is never going to roll out
+	exists := func(store cache.Store) (bool, error) {
+		_, found, err := store.GetByKey(w.Namespace + "/" + w.Name)
+		if err != nil {
+			return true, err
+		}
+		if !found {
+			return true, fmt.Errorf("%s not found", w)
+		}
+		return false, nil
+	}
+	_, err = toolwatch.UntilWithSync(ctx, lw, obj, exists, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Deleted:
+			return false, fmt.Errorf("%s was deleted", w)
+		case watch.Error:
+			return false, fmt.Errorf("watch %s failed: %v", w, event.Object)
+		}
+		status, err := rolloutStatus(w, event.Object)
+		if err != nil {
+			return false, err
+		}
+		result = status
+		slog.Debug("rollout status", "workload", w.String(), "done", result.Done, "message", result.Message)
+		return result.Done, nil
+	})
+	if err != nil {
+		slog.Error("wait for rollout failed", "workload", w.String(), "message", result.Message, "error", err)
+		return result, err
+	}
+	return result, nil
+}
+
+// WaitForRollouts waits for several workloads concurrently under one overall timeout
+func WaitForRollouts(ctx context.Context, clientset kubernetes.Interface, timeout time.Duration, workloads ...Workload) ([]RolloutResult, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	results := make([]RolloutResult, len(workloads))
+	errs := make([]error, len(workloads))
+	wg := sync.WaitGroup{}
+	for i, w := range workloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = WaitForRollout(ctx, clientset, w)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", w, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+func rolloutListWatch(clientset kubernetes.Interface, w Workload) (cache.ListerWatcher, runtime.Object, error) {
+	apps := clientset.AppsV1()
+	switch w.Kind {
+	case KindDeployment:
+		return namedListWatch(clientset, apps.Deployments(w.Namespace), w.Name), &appsv1.Deployment{}, nil
+	case KindStatefulSet:
+		return namedListWatch(clientset, apps.StatefulSets(w.Namespace), w.Name), &appsv1.StatefulSet{}, nil
+	case KindDaemonSet:
+		return namedListWatch(clientset, apps.DaemonSets(w.Namespace), w.Name), &appsv1.DaemonSet{}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported workload kind %q", w.Kind)
+}
+
+// listWatchClient is the List and Watch of a typed client like DeploymentInterface
+type listWatchClient[L runtime.Object] interface {
+	List(ctx context.Context, opts metav1.ListOptions) (L, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// namedListWatch lists and watches the single object called name
+func namedListWatch[L runtime.Object](clientset kubernetes.Interface, client listWatchClient[L], name string) cache.ListerWatcher {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return cache.ToListWatcherWithWatchListSemantics(&cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return client.List(ctx, opts)
+		},
+		WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector
+			return client.Watch(ctx, opts)
+		},
+	}, clientset)
+}
+
+// rolloutStatus mirrors the checks of kubectl rollout status for each kind
+func rolloutStatus(w Workload, obj runtime.Object) (RolloutResult, error) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return deploymentStatus(w, o)
+	case *appsv1.StatefulSet:
+		return statefulSetStatus(w, o), nil
+	case *appsv1.DaemonSet:
+		return daemonSetStatus(w, o), nil
+	}
+	return RolloutResult{Workload: w}, fmt.Errorf("unexpected object %T for %s", obj, w)
+}
+
+func deploymentStatus(w Workload, d *appsv1.Deployment) (RolloutResult, error) {
+	r := RolloutResult{
+		Workload:           w,
+		Generation:         d.Generation,
+		ObservedGeneration: d.Status.ObservedGeneration,
+		Desired:            replicasOrDefault(d.Spec.Replicas),
+		Updated:            d.Status.UpdatedReplicas,
+		Available:          d.Status.AvailableReplicas,
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		r.Message = "waiting for deployment spec update to be observed"
+		return r, nil
+	}
+	if d.Spec.Paused {
+		r.Paused = true
+		r.Message = fmt.Sprintf("deployment %q is paused", d.Name)
+		return r, fmt.Errorf("deployment %q: %w", d.Name, ErrRolloutPaused)
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			r.Message = c.Message
+			return r, fmt.Errorf("deployment %q: %w", d.Name, ErrProgressDeadlineExceeded)
+		}
+	}
+	switch {
+	case r.Updated < r.Desired:
+		r.Message = fmt.Sprintf("%d out of %d new replicas have been updated", r.Updated, r.Desired)
+	case d.Status.Replicas > r.Updated:
+		r.Message = fmt.Sprintf("%d old replicas are pending termination", d.Status.Replicas-r.Updated)
+	case r.Available < r.Updated:
+		r.Message = fmt.Sprintf("%d of %d updated replicas are available", r.Available, r.Updated)
+	default:
+		r.Done = true
+		r.Message = fmt.Sprintf("deployment %q successfully rolled out", d.Name)
+	}
+	return r, nil
+}
+
+func statefulSetStatus(w Workload, s *appsv1.StatefulSet) RolloutResult {
+	r := RolloutResult{
+		Workload:           w,
+		Generation:         s.Generation,
+		ObservedGeneration: s.Status.ObservedGeneration,
+		Desired:            replicasOrDefault(s.Spec.Replicas),
+		Updated:            s.Status.UpdatedReplicas,
+		Available:          s.Status.AvailableReplicas,
+	}
+	if s.Status.ObservedGeneration == 0 || s.Generation > s.Status.ObservedGeneration {
+		r.Message = "waiting for statefulset spec update to be observed"
+		return r
+	}
+	if s.Status.ReadyReplicas < r.Desired {
+		r.Message = fmt.Sprintf("%d of %d pods are ready", s.Status.ReadyReplicas, r.Desired)
+		return r
+	}
+	if ru := s.Spec.UpdateStrategy.RollingUpdate; s.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType &&
+		ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		if r.Updated < r.Desired-*ru.Partition {
+			r.Message = fmt.Sprintf("%d of %d partitioned pods have been updated", r.Updated, r.Desired-*ru.Partition)
+			return r
+		}
+		r.Done = true
+		r.Message = fmt.Sprintf("partitioned roll out complete: %d new pods have been updated", r.Updated)
+		return r
+	}
+	if s.Status.UpdateRevision != s.Status.CurrentRevision {
+		r.Message = fmt.Sprintf("%d pods at revision %s, waiting for rolling update to complete", r.Updated, s.Status.UpdateRevision)
+		return r
+	}
+	r.Done = true
+	r.Message = fmt.Sprintf("statefulset rolling update complete %d pods at revision %s", s.Status.CurrentReplicas, s.Status.CurrentRevision)
+	return r
+}
+
+func daemonSetStatus(w Workload, d *appsv1.DaemonSet) RolloutResult {
+	r := RolloutResult{
+		Workload:           w,
+		Generation:         d.Generation,
+		ObservedGeneration: d.Status.ObservedGeneration,
+		Desired:            d.Status.DesiredNumberScheduled,
+		Updated:            d.Status.UpdatedNumberScheduled,
+		Available:          d.Status.NumberAvailable,
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		r.Message = "waiting for daemon set spec update to be observed"
+		return r
+	}
+	switch {
+	case r.Updated < r.Desired:
+		r.Message = fmt.Sprintf("%d out of %d new pods have been updated", r.Updated, r.Desired)
+	case r.Available < r.Desired:
+		r.Message = fmt.Sprintf("%d of %d updated pods are available", r.Available, r.Desired)
+	default:
+		r.Done = true
+		r.Message = fmt.Sprintf("daemon set %q successfully rolled out", d.Name)
+	}
+	return r
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
@@ -0,0 +1,171 @@
+package tour
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func rolloutDeployment(replicas, updated, available int32, generation, observed int64) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "memcached", Generation: generation},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: observed,
+			Replicas:           replicas,
+			UpdatedReplicas:    updated,
+			AvailableReplicas:  available,
+		},
+	}
+}
+
+func TestRolloutStatus(t *testing.T) {
+	three := int32(3)
+	partition := int32(2)
+	tests := []struct {
+		name     string
+		kind     WorkloadKind
+		obj      runtime.Object
+		wantDone bool
+		wantErr  error
+	}{
+		{"deploy-done", KindDeployment, rolloutDeployment(3, 3, 3, 2, 2), true, nil},
+		{"deploy-not-observed", KindDeployment, rolloutDeployment(3, 3, 3, 3, 2), false, nil},
+		{"deploy-updating", KindDeployment, rolloutDeployment(3, 1, 3, 2, 2), false, nil},
+		{"deploy-unavailable", KindDeployment, rolloutDeployment(3, 3, 2, 2, 2), false, nil},
+		{"deploy-deadline", KindDeployment, func() runtime.Object {
+			d := rolloutDeployment(3, 1, 1, 2, 2)
+			d.Status.Conditions = []appsv1.DeploymentCondition{{
+				Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded",
+			}}
+			return d
+		}(), false, ErrProgressDeadlineExceeded},
+		{"deploy-paused", KindDeployment, func() runtime.Object {
+			d := rolloutDeployment(3, 1, 1, 2, 2)
+			d.Spec.Paused = true
+			return d
+		}(), false, ErrRolloutPaused},
+		{"sts-done", KindStatefulSet, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &three},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 3,
+				CurrentRevision: "r2", UpdateRevision: "r2",
+			},
+		}, true, nil},
+		{"sts-revision", KindStatefulSet, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &three},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 1,
+				CurrentRevision: "r1", UpdateRevision: "r2",
+			},
+		}, false, nil},
+		{"sts-partition", KindStatefulSet, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 1},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &three,
+				UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+					Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+				},
+			},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 1,
+				CurrentRevision: "r1", UpdateRevision: "r2",
+			},
+		}, true, nil},
+		{"ds-done", KindDaemonSet, &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 4},
+			Status: appsv1.DaemonSetStatus{
+				ObservedGeneration: 4, DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 2,
+			},
+		}, true, nil},
+		{"ds-updating", KindDaemonSet, &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: 4},
+			Status: appsv1.DaemonSetStatus{
+				ObservedGeneration: 4, DesiredNumberScheduled: 2, UpdatedNumberScheduled: 1, NumberAvailable: 2,
+			},
+		}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rolloutStatus(Workload{Kind: tt.kind}, tt.obj)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("rolloutStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Done != tt.wantDone {
+				t.Errorf("rolloutStatus() done = %v, want %v, message = %s", got.Done, tt.wantDone, got.Message)
+			}
+		})
+	}
+}
+
+func TestWaitForRollout(t *testing.T) {
+	clientset := fake.NewClientset(rolloutDeployment(3, 1, 1, 2, 2))
+	w := Workload{Kind: KindDeployment, Namespace: "default", Name: "memcached"}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = clientset.AppsV1().Deployments("default").UpdateStatus(
+			context.Background(), rolloutDeployment(3, 3, 3, 2, 2), metav1.UpdateOptions{})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := WaitForRollout(ctx, clientset, w)
+	if err != nil {
+		t.Fatalf("WaitForRollout() error = %v", err)
+	}
+	if !got.Done || got.Available != 3 {
+		t.Errorf("WaitForRollout() got = %+v, want done with 3 available", got)
+	}
+}
+
+func TestWaitForRolloutGone(t *testing.T) {
+	clientset := fake.NewClientset(rolloutDeployment(3, 1, 1, 2, 2))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	missing := Workload{Kind: KindStatefulSet, Namespace: "default", Name: "memcached"}
+	if _, err := WaitForRollout(ctx, clientset, missing); err == nil || ctx.Err() != nil {
+		t.Errorf("WaitForRollout() missing workload error = %v, want not found before the timeout", err)
+	}
+
+	w := Workload{Kind: KindDeployment, Namespace: "default", Name: "memcached"}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = clientset.AppsV1().Deployments("default").Delete(context.Background(), "memcached", metav1.DeleteOptions{})
+	}()
+	if _, err := WaitForRollout(ctx, clientset, w); err == nil || ctx.Err() != nil {
+		t.Errorf("WaitForRollout() deleted workload error = %v, want deleted before the timeout", err)
+	}
+}
+
+func TestWaitForRollouts(t *testing.T) {
+	done := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agent"},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, UpdatedNumberScheduled: 1, NumberAvailable: 1},
+	}
+	clientset := fake.NewClientset(rolloutDeployment(3, 1, 1, 2, 2), done)
+
+	got, err := WaitForRollouts(context.Background(), clientset, 200*time.Millisecond,
+		Workload{Kind: KindDaemonSet, Namespace: "default", Name: "agent"},
+		Workload{Kind: KindDeployment, Namespace: "default", Name: "memcached"},
+	)
+	if err == nil {
+		t.Fatalf("WaitForRollouts() error = nil, want timeout")
+	}
+	if !got[0].Done {
+		t.Errorf("WaitForRollouts() daemonset result = %+v, want done", got[0])
+	}
+	if got[1].Done || got[1].Updated != 1 {
+		t.Errorf("WaitForRollouts() deployment result = %+v, want pending with 1 updated", got[1])
+	}
+}
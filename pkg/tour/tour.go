@@ -31,6 +31,17 @@ func NewKubeClient(kubeconfig string) (kubernetes.Interface, error) {
 	return kubernetes.NewForConfig(config)
 }
 
+// NewRestConfig loads the rest config of a kubeconfig, for streaming APIs the
+// typed clients do not cover
+func NewRestConfig(kubeconfig string) (*rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		slog.Error("load kubeconfig failed", "error", err)
+		return nil, err
+	}
+	return config, nil
+}
+
 // NewKubeClientInner creates a client inside a Kubernetes cluster
 func NewKubeClientInner() (kubernetes.Interface, error) {
 	config, err := rest.InClusterConfig()
@@ -91,7 +102,8 @@ func GetNode(clientset kubernetes.Interface, name string) (*corev1.Node, error)
 	return node, nil
 }
 
-func isMaster(node *corev1.Node) bool {
+// IsMaster reports whether the node has the master role label
+func IsMaster(node *corev1.Node) bool {
 	if node == nil {
 		return false
 	}
@@ -99,7 +111,8 @@ func isMaster(node *corev1.Node) bool {
 	return ok
 }
 
-func isReady(node *corev1.Node) bool {
+// IsReady reports whether the node is ready
+func IsReady(node *corev1.Node) bool {
 	if node == nil {
 		return false
 	}
@@ -118,7 +131,8 @@ func nodeAge(node *corev1.Node) time.Duration {
 	return time.Now().Sub(node.CreationTimestamp.Time)
 }
 
-func kubeletVersion(node *corev1.Node) string {
+// KubeletVersion returns the kubelet version of the node
+func KubeletVersion(node *corev1.Node) string {
 	if node == nil {
 		return ""
 	}
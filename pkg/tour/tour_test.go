@@ -99,7 +99,7 @@ func TestGetNode(t *testing.T) {
 				return
 			}
 			if got != nil {
-				slog.Info("node info", "name", got.Name, "isMaster", isMaster(got), "isReady", isReady(got), "age", nodeAge(got), "kubelet", kubeletVersion(got))
+				slog.Info("node info", "name", got.Name, "isMaster", IsMaster(got), "isReady", IsReady(got), "age", nodeAge(got), "kubelet", KubeletVersion(got))
 			}
 		})
 	}
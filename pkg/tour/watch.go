@@ -0,0 +1,252 @@
+package tour
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	kerrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	toolwatch "k8s.io/client-go/tools/watch"
+)
+
+// WatchEvent is a watch event carrying the object as its concrete type
+type WatchEvent[T runtime.Object] struct {
+	Type   watch.EventType
+	Object T
+}
+
+// WatchOptions filters the watched objects
+type WatchOptions struct {
+	LabelSelector string
+	FieldSelector string
+	// SkipInitial drops the Added events of the objects existing at the first list.
+	SkipInitial bool
+	// RelistInterval waits between relists after the watch ended, 1s by default.
+	RelistInterval time.Duration
+}
+
+func (o WatchOptions) merge(opts metav1.ListOptions) metav1.ListOptions {
+	opts.LabelSelector = o.LabelSelector
+	opts.FieldSelector = o.FieldSelector
+	return opts
+}
+
+// Watch lists the objects of lw to start watching from a real resourceVersion,
+// then streams typed events until ctx is done. When the resourceVersion expires
+// it relists and emits the changes missed meanwhile, including deletions.
+func Watch[T runtime.Object](ctx context.Context, lw cache.ListerWatcherWithContext, opts WatchOptions) (<-chan WatchEvent[T], error) {
+	if opts.RelistInterval <= 0 {
+		opts.RelistInterval = time.Second
+	}
+	w := &typedWatch[T]{lw: lw, opts: opts, known: map[string]T{}, events: make(chan WatchEvent[T])}
+	// The first list fails fast, later relists retry until ctx is done
+	initial, rv, err := w.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go w.run(ctx, initial, rv)
+	return w.events, nil
+}
+
+// Resume watches lw from rv, like after a restart, without listing first. The
+// known objects are those seen up to rv; when rv expired, the relist emits the
+// changes against them, so stubs carrying only the key and resourceVersion do.
+func Resume[T runtime.Object](ctx context.Context, lw cache.ListerWatcherWithContext, opts WatchOptions, rv string, known []T) (<-chan WatchEvent[T], error) {
+	if rv == "" || rv == "0" {
+		return nil, fmt.Errorf("cannot resume from resourceVersion %q", rv)
+	}
+	if opts.RelistInterval <= 0 {
+		opts.RelistInterval = time.Second
+	}
+	w := &typedWatch[T]{lw: lw, opts: opts, known: map[string]T{}, events: make(chan WatchEvent[T])}
+	for _, obj := range known {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			w.known[key] = obj
+		}
+	}
+	go func() {
+		defer close(w.events)
+		w.loop(ctx, rv)
+	}()
+	return w.events, nil
+}
+
+type typedWatch[T runtime.Object] struct {
+	lw     cache.ListerWatcherWithContext
+	opts   WatchOptions
+	known  map[string]T
+	events chan WatchEvent[T]
+}
+
+func (w *typedWatch[T]) list(ctx context.Context) ([]T, string, error) {
+	list, err := w.lw.ListWithContext(ctx, w.opts.merge(metav1.ListOptions{}))
+	if err != nil {
+		slog.Error("list failed", "error", err)
+		return nil, "", err
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, "", err
+	}
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]T, 0, len(objs))
+	for _, obj := range objs {
+		item, ok := obj.(T)
+		if !ok {
+			return nil, "", fmt.Errorf("list returned %T, want %T", obj, *new(T))
+		}
+		items = append(items, item)
+	}
+	return items, listMeta.GetResourceVersion(), nil
+}
+
+func (w *typedWatch[T]) send(ctx context.Context, typ watch.EventType, obj T) bool {
+	select {
+	case w.events <- WatchEvent[T]{Type: typ, Object: obj}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sync emits the difference between the known objects and a fresh list
+func (w *typedWatch[T]) sync(ctx context.Context, items []T, emit bool) bool {
+	seen := map[string]bool{}
+	for _, item := range items {
+		key, err := cache.MetaNamespaceKeyFunc(item)
+		if err != nil {
+			continue
+		}
+		seen[key] = true
+		old, ok := w.known[key]
+		w.known[key] = item
+		switch {
+		case !emit:
+		case !ok:
+			if !w.send(ctx, watch.Added, item) {
+				return false
+			}
+		case resourceVersion(old) != resourceVersion(item):
+			if !w.send(ctx, watch.Modified, item) {
+				return false
+			}
+		}
+	}
+	for key, old := range w.known {
+		if seen[key] {
+			continue
+		}
+		delete(w.known, key)
+		if !w.send(ctx, watch.Deleted, old) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *typedWatch[T]) run(ctx context.Context, items []T, rv string) {
+	defer close(w.events)
+	if !w.sync(ctx, items, !w.opts.SkipInitial) {
+		return
+	}
+	w.loop(ctx, rv)
+}
+
+// loop watches from rv and relists whenever the watch ends
+func (w *typedWatch[T]) loop(ctx context.Context, rv string) {
+	for {
+		w.watch(ctx, rv)
+		if ctx.Err() != nil {
+			return
+		}
+		// The resourceVersion expired or the watch gave up, catch up by relisting
+		var items []T
+		var err error
+		for {
+			items, rv, err = w.list(ctx)
+			if err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.opts.RelistInterval):
+			}
+		}
+		slog.Info("relisted", "objects", len(items), "resourceVersion", rv)
+		if !w.sync(ctx, items, true) {
+			return
+		}
+	}
+}
+
+// watch forwards events from a RetryWatcher started at rv until it stops. The
+// RetryWatcher swallows bookmarks and resumes dropped watches by itself.
+func (w *typedWatch[T]) watch(ctx context.Context, rv string) {
+	watcher, err := toolwatch.NewRetryWatcherWithContext(ctx, rv, &cache.ListWatch{
+		WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			return w.lw.WatchWithContext(ctx, w.opts.merge(opts))
+		},
+	})
+	if err != nil {
+		slog.Error("create retry watcher failed", "resourceVersion", rv, "error", err)
+		return
+	}
+	defer watcher.Stop()
+
+	for {
+		var event watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok = <-watcher.ResultChan():
+		}
+		if !ok {
+			return
+		}
+		if event.Type == watch.Error {
+			err := kerrs.FromObject(event.Object)
+			if kerrs.IsResourceExpired(err) || kerrs.IsGone(err) {
+				slog.Warn("resource version expired, relisting", "resourceVersion", rv)
+			} else {
+				slog.Error("watch failed", "error", err)
+			}
+			return
+		}
+		obj, ok := event.Object.(T)
+		if !ok {
+			slog.Warn("unexpected watch object", "type", event.Type, "object", fmt.Sprintf("%T", event.Object))
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err == nil {
+			if event.Type == watch.Deleted {
+				delete(w.known, key)
+			} else {
+				w.known[key] = obj
+			}
+		}
+		rv = resourceVersion(obj)
+		if !w.send(ctx, event.Type, obj) {
+			return
+		}
+	}
+}
+
+func resourceVersion(obj runtime.Object) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetResourceVersion()
+}
@@ -0,0 +1,158 @@
+package tour
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func watchNamespace(name, rv string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: rv}}
+}
+
+func namespaceList(rv string, items ...*corev1.Namespace) *corev1.NamespaceList {
+	list := &corev1.NamespaceList{ListMeta: metav1.ListMeta{ResourceVersion: rv}}
+	for _, ns := range items {
+		list.Items = append(list.Items, *ns)
+	}
+	return list
+}
+
+func TestWatch(t *testing.T) {
+	lists := []*corev1.NamespaceList{
+		namespaceList("10", watchNamespace("a", "5"), watchNamespace("b", "6")),
+		namespaceList("20", watchNamespace("a", "13"), watchNamespace("c", "11"), watchNamespace("d", "19")),
+	}
+	watchers := []*watch.FakeWatcher{watch.NewFakeWithChanSize(10, false), watch.NewFakeWithChanSize(10, false)}
+	mu := sync.Mutex{}
+	listOpts, watchOpts := []metav1.ListOptions{}, []metav1.ListOptions{}
+
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(_ context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			list := lists[len(listOpts)]
+			listOpts = append(listOpts, opts)
+			return list, nil
+		},
+		WatchFuncWithContext: func(_ context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			w := watchers[len(watchOpts)]
+			watchOpts = append(watchOpts, opts)
+			return w, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := Watch[*corev1.Namespace](ctx, lw, WatchOptions{LabelSelector: "team=a", RelistInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	watchers[0].Add(watchNamespace("c", "11"))
+	watchers[0].Action(watch.Bookmark, watchNamespace("", "12"))
+	watchers[0].Modify(watchNamespace("a", "13"))
+	watchers[0].Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+	watchers[1].Delete(watchNamespace("c", "21"))
+
+	want := []string{"ADDED a", "ADDED b", "ADDED c", "MODIFIED a", "ADDED d", "DELETED b", "DELETED c"}
+	for i, w := range want {
+		select {
+		case event := <-events:
+			if got := string(event.Type) + " " + event.Object.Name; got != w {
+				t.Errorf("Watch() event %d = %s, want %s", i, got, w)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Watch() timed out waiting for event %d %s", i, w)
+		}
+	}
+
+	mu.Lock()
+	if len(listOpts) != 2 || listOpts[0].LabelSelector != "team=a" {
+		t.Errorf("Watch() list options = %+v, want two lists with the label selector", listOpts)
+	}
+	if len(watchOpts) != 2 || watchOpts[0].ResourceVersion != "10" || watchOpts[1].ResourceVersion != "20" ||
+		!watchOpts[0].AllowWatchBookmarks || watchOpts[0].LabelSelector != "team=a" {
+		t.Errorf("Watch() watch options = %+v, want resuming from the listed resource versions", watchOpts)
+	}
+	mu.Unlock()
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestResume(t *testing.T) {
+	watcher := watch.NewFakeWithChanSize(10, false)
+	mu := sync.Mutex{}
+	lists, watchOpts := 0, []metav1.ListOptions{}
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(_ context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lists++
+			return namespaceList("30", watchNamespace("a", "5"), watchNamespace("b", "25"), watchNamespace("e", "28")), nil
+		},
+		WatchFuncWithContext: func(_ context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			watchOpts = append(watchOpts, opts)
+			if len(watchOpts) == 1 {
+				return watcher, nil
+			}
+			return watch.NewFake(), nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := Resume(ctx, lw, WatchOptions{}, "", []*corev1.Namespace{}); err == nil {
+		t.Errorf("Resume() from an empty resourceVersion error = nil")
+	}
+	// The checkpoint knew a, b and c at resourceVersion 10
+	known := []*corev1.Namespace{watchNamespace("a", "5"), watchNamespace("b", "6"), watchNamespace("c", "7")}
+	events, err := Resume(ctx, lw, WatchOptions{RelistInterval: 10 * time.Millisecond}, "10", known)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+
+	watcher.Add(watchNamespace("d", "11"))
+	watcher.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired})
+
+	want := []string{"ADDED d", "MODIFIED b", "ADDED e", "DELETED c", "DELETED d"}
+	var got []string
+	for range want {
+		select {
+		case event := <-events:
+			got = append(got, string(event.Type)+" "+event.Object.Name)
+		case <-ctx.Done():
+			t.Fatalf("Resume() timed out after events %v", got)
+		}
+	}
+	// Deletions follow the map order
+	slices.Sort(got[3:])
+	if !slices.Equal(got, want) {
+		t.Errorf("Resume() events = %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	if lists != 1 || watchOpts[0].ResourceVersion != "10" {
+		t.Errorf("Resume() listed %d times and watched from %q, want one relist after resuming from 10", lists, watchOpts[0].ResourceVersion)
+	}
+	mu.Unlock()
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,39 @@
+package tour
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+)
+
+// PodWorkload resolves the workload controlling a pod, a bare pod resolves to
+// itself. A ReplicaSet is followed to its Deployment through replicaSets when
+// given, one missing from the lister stands for itself. Without a lister the
+// Deployment is told by the pod-template-hash suffix and its UID is unknown.
+func PodWorkload(pod *corev1.Pod, replicaSets appslisters.ReplicaSetLister) corev1.ObjectReference {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID}
+	}
+	ref := corev1.ObjectReference{APIVersion: owner.APIVersion, Kind: owner.Kind, Namespace: pod.Namespace, Name: owner.Name, UID: owner.UID}
+	if owner.Kind != "ReplicaSet" {
+		return ref
+	}
+	if replicaSets != nil {
+		if rs, err := replicaSets.ReplicaSets(pod.Namespace).Get(owner.Name); err == nil {
+			if parent := metav1.GetControllerOf(rs); parent != nil {
+				ref.APIVersion, ref.Kind, ref.Name, ref.UID = parent.APIVersion, parent.Kind, parent.Name, parent.UID
+			}
+		}
+		return ref
+	}
+	if hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; hash != "" {
+		if name, ok := strings.CutSuffix(owner.Name, "-"+hash); ok {
+			ref.Kind, ref.Name, ref.UID = "Deployment", name, ""
+		}
+	}
+	return ref
+}
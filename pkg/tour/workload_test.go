@@ -0,0 +1,60 @@
+package tour
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodWorkload(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	err := indexer.Add(&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "shop",
+		Name:      "web-5d8f7",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "Deployment", Name: "web", UID: "uid-deploy", Controller: new(true),
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister := appslisters.NewReplicaSetLister(indexer)
+
+	tests := []struct {
+		name        string
+		owner       *metav1.OwnerReference
+		hash        string
+		replicaSets appslisters.ReplicaSetLister
+		want        string
+		wantUID     string
+	}{
+		{name: "deployment by hash", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-5d8f7", UID: "uid-rs", Controller: new(true)}, hash: "5d8f7", want: "Deployment/web"},
+		{name: "deployment by lister", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web-5d8f7", UID: "uid-rs", Controller: new(true)}, replicaSets: lister, want: "Deployment/web", wantUID: "uid-deploy"},
+		{name: "replicaset not in lister", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "api-7c9d", UID: "uid-rs", Controller: new(true)}, hash: "7c9d", replicaSets: lister, want: "ReplicaSet/api-7c9d", wantUID: "uid-rs"},
+		{name: "bare replicaset", owner: &metav1.OwnerReference{Kind: "ReplicaSet", Name: "web", UID: "uid-rs", Controller: new(true)}, want: "ReplicaSet/web", wantUID: "uid-rs"},
+		{name: "statefulset", owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db", UID: "uid-sts", Controller: new(true)}, want: "StatefulSet/db", wantUID: "uid-sts"},
+		{name: "not controller", owner: &metav1.OwnerReference{Kind: "StatefulSet", Name: "db"}, want: "Pod/debug", wantUID: "uid-pod"},
+		{name: "bare pod", want: "Pod/debug", wantUID: "uid-pod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "shop",
+				Name:      "debug",
+				UID:       "uid-pod",
+				Labels:    map[string]string{"pod-template-hash": tt.hash},
+			}}
+			if tt.owner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*tt.owner}
+			}
+			ref := PodWorkload(pod, tt.replicaSets)
+			if got := ref.Kind + "/" + ref.Name; got != tt.want || string(ref.UID) != tt.wantUID || ref.Namespace != "shop" {
+				t.Errorf("PodWorkload() = %+v, want %s with uid %q", ref, tt.want, tt.wantUID)
+			}
+		})
+	}
+}